@@ -5,12 +5,23 @@ import (
 	"fmt"
 	"log"
 
+	_ "trpc-go-note/examples/filters/common" // Import filters
 	pb "trpc-go-note/examples/helloworld/pb"
 
+	trpc "trpc.group/trpc-go/trpc-go"
 	"trpc.group/trpc-go/trpc-go/client"
 )
 
 func main() {
+	// Load trpc_go.yaml: the `client.filter` chain and the `plugins.filter` configs
+	cfg, err := trpc.LoadConfig(trpc.ServerConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := trpc.Setup(cfg); err != nil {
+		log.Fatal(err)
+	}
+
 	// Create client proxy
 	// Note: We inject metadata for AuthFilter
 	proxy := pb.NewGreeterClientProxy(
@@ -44,4 +55,11 @@ func main() {
 	if err != nil {
 		log.Printf("Received Expected Error: %v\n", err)
 	}
+
+	// 4. Invalid Request (Test Validate)
+	fmt.Println("\n--- Test 4: Invalid Request ---")
+	_, err = proxy.Hello(context.Background(), &pb.HelloRequest{})
+	if err != nil {
+		log.Printf("Received Expected Error: %v\n", err)
+	}
 }
@@ -0,0 +1,308 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/admin"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// Adaptive limit algorithms.
+const (
+	AlgorithmAIMD     = "aimd"
+	AlgorithmGradient = "gradient"
+)
+
+// AdaptiveLimitConfig is the `plugins.filter.adaptive_limit` section of trpc_go.yaml.
+// Every zero field falls back to its default.
+type AdaptiveLimitConfig struct {
+	Algorithm    string `yaml:"algorithm"`     // aimd (default) or gradient
+	InitialLimit int    `yaml:"initial_limit"` // default 20
+	MinLimit     int    `yaml:"min_limit"`     // default 1
+	MaxLimit     int    `yaml:"max_limit"`     // default 1000
+
+	// AIMD: a request slower than Timeout or failed by overload is a drop,
+	// a drop multiplies the limit by Backoff, otherwise the limit grows by one.
+	Timeout time.Duration `yaml:"timeout"` // default 1s
+	Backoff float64       `yaml:"backoff"` // default 0.9
+
+	// Gradient: the limit follows longRTT/shortRTT, Tolerance is how much latency
+	// growth is accepted before shrinking and Smoothing damps each change.
+	Tolerance float64 `yaml:"tolerance"` // default 1.5
+	Smoothing float64 `yaml:"smoothing"` // default 0.2
+}
+
+func (c *AdaptiveLimitConfig) normalize() error {
+	switch c.Algorithm {
+	case "":
+		c.Algorithm = AlgorithmAIMD
+	case AlgorithmAIMD, AlgorithmGradient:
+	default:
+		return fmt.Errorf("adaptive_limit: unknown algorithm %q", c.Algorithm)
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = 1
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 1000
+	}
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = 20
+	}
+	if c.MinLimit > c.MaxLimit || c.InitialLimit < c.MinLimit || c.InitialLimit > c.MaxLimit {
+		return fmt.Errorf("adaptive_limit: limits must satisfy min <= initial <= max, got %d, %d, %d",
+			c.MinLimit, c.InitialLimit, c.MaxLimit)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Backoff <= 0 || c.Backoff >= 1 {
+		c.Backoff = 0.9
+	}
+	if c.Tolerance < 1 {
+		c.Tolerance = 1.5
+	}
+	if c.Smoothing <= 0 || c.Smoothing > 1 {
+		c.Smoothing = 0.2
+	}
+	return nil
+}
+
+// limitAlgorithm computes the next concurrency limit from one finished request.
+type limitAlgorithm interface {
+	update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// aimdAlgorithm is additive increase, multiplicative decrease.
+type aimdAlgorithm struct {
+	timeout time.Duration
+	backoff float64
+}
+
+func (a *aimdAlgorithm) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || rtt > a.timeout {
+		return limit * a.backoff
+	}
+	// Only grow when the limit is actually used, or an idle service grows forever.
+	if float64(inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// gradientAlgorithm compares a long-term RTT average, the latency without queueing,
+// with the current RTT: a growing RTT means requests queue up, so the limit shrinks.
+type gradientAlgorithm struct {
+	tolerance float64
+	smoothing float64
+	longRTT   float64 // EWMA in nanoseconds
+}
+
+// longRTTWeight makes longRTT an average over roughly the last 600 samples.
+const longRTTWeight = 2.0 / 601
+
+func (g *gradientAlgorithm) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	short := math.Max(1, float64(rtt))
+	if g.longRTT == 0 {
+		g.longRTT = short
+	}
+	g.longRTT += (short - g.longRTT) * longRTTWeight
+	// Recover quickly after a latency spike so longRTT does not stay inflated.
+	if g.longRTT/short > 2 {
+		g.longRTT *= 0.95
+	}
+	if float64(inflight) < limit/2 && !dropped {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRTT/short))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + newLimit*g.smoothing
+}
+
+// AdaptiveLimiter caps the in-flight requests of one service with a limit
+// adjusted after every request.
+type AdaptiveLimiter struct {
+	name     string
+	min, max float64
+	algo     limitAlgorithm
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	rejected uint64
+}
+
+// NewAdaptiveLimiter creates an AdaptiveLimiter, cfg must have been normalized.
+func NewAdaptiveLimiter(name string, cfg *AdaptiveLimitConfig) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		name:  name,
+		min:   float64(cfg.MinLimit),
+		max:   float64(cfg.MaxLimit),
+		limit: float64(cfg.InitialLimit),
+	}
+	if cfg.Algorithm == AlgorithmGradient {
+		l.algo = &gradientAlgorithm{tolerance: cfg.Tolerance, smoothing: cfg.Smoothing}
+	} else {
+		l.algo = &aimdAlgorithm{timeout: cfg.Timeout, backoff: cfg.Backoff}
+	}
+	metrics.Gauge(l.metricName("limit")).Set(l.limit)
+	return l
+}
+
+func (l *AdaptiveLimiter) metricName(metric string) string {
+	return "adaptive_limit." + l.name + "." + metric
+}
+
+// Acquire takes a slot, it returns false when the limit is reached.
+func (l *AdaptiveLimiter) Acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if float64(l.inflight) >= math.Floor(l.limit) {
+		l.rejected++
+		return false
+	}
+	l.inflight++
+	metrics.Gauge(l.metricName("inflight")).Set(float64(l.inflight))
+	return true
+}
+
+// Release gives the slot back and feeds the request result to the algorithm.
+func (l *AdaptiveLimiter) Release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.algo.update(l.limit, rtt, l.inflight, dropped)
+	l.limit = math.Max(l.min, math.Min(l.max, limit))
+	l.inflight--
+	metrics.Gauge(l.metricName("limit")).Set(l.limit)
+	metrics.Gauge(l.metricName("inflight")).Set(float64(l.inflight))
+}
+
+// AdaptiveLimitStatus is a snapshot of an AdaptiveLimiter.
+type AdaptiveLimitStatus struct {
+	Limit    int    `json:"limit"`
+	Inflight int    `json:"inflight"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// Status returns the current state of the limiter.
+func (l *AdaptiveLimiter) Status() AdaptiveLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return AdaptiveLimitStatus{Limit: int(l.limit), Inflight: l.inflight, Rejected: l.rejected}
+}
+
+var adaptiveLimit = struct {
+	mu       sync.RWMutex
+	cfg      AdaptiveLimitConfig
+	limiters map[string]*AdaptiveLimiter // service name => limiter
+}{
+	limiters: make(map[string]*AdaptiveLimiter),
+}
+
+func getAdaptiveLimiter(service string) *AdaptiveLimiter {
+	adaptiveLimit.mu.RLock()
+	l, ok := adaptiveLimit.limiters[service]
+	adaptiveLimit.mu.RUnlock()
+	if ok {
+		return l
+	}
+
+	adaptiveLimit.mu.Lock()
+	defer adaptiveLimit.mu.Unlock()
+	if l, ok := adaptiveLimit.limiters[service]; ok {
+		return l
+	}
+	l = NewAdaptiveLimiter(service, &adaptiveLimit.cfg)
+	adaptiveLimit.limiters[service] = l
+	return l
+}
+
+// isOverloadErr reports whether err means the request was dropped because of load.
+func isOverloadErr(err error) bool {
+	switch errs.Code(err) {
+	case errs.RetServerTimeout, errs.RetServerFullLinkTimeout, errs.RetServerOverload:
+		return true
+	}
+	return false
+}
+
+// AdaptiveLimitFilter limits the in-flight requests of each service with a limit
+// learned from the request latency, see AdaptiveLimitConfig.
+func AdaptiveLimitFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	service := codec.Message(ctx).CalleeServiceName()
+	l := getAdaptiveLimiter(service)
+	if !l.Acquire() {
+		metrics.Counter(l.metricName("rejected")).Incr()
+		return nil, errs.NewFrameError(errs.RetServerOverload,
+			fmt.Sprintf("adaptive_limit: %s reaches concurrency limit", service))
+	}
+
+	start := time.Now()
+	defer func() {
+		l.Release(time.Since(start), isOverloadErr(err) || ctx.Err() == context.DeadlineExceeded)
+	}()
+	return next(ctx, req)
+}
+
+// handleAdaptiveLimit shows the limiters by "http://ip:port/cmds/adaptive_limit".
+func handleAdaptiveLimit(w http.ResponseWriter, r *http.Request) {
+	adaptiveLimit.mu.RLock()
+	status := make(map[string]AdaptiveLimitStatus, len(adaptiveLimit.limiters))
+	for name, l := range adaptiveLimit.limiters {
+		status[name] = l.Status()
+	}
+	algorithm := adaptiveLimit.cfg.Algorithm
+	adaptiveLimit.mu.RUnlock()
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"algorithm": algorithm,
+		"limiters":  status,
+	})
+}
+
+// AdaptiveLimitPluginFactory configures the algorithm of AdaptiveLimitFilter.
+type AdaptiveLimitPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *AdaptiveLimitPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config. It must run before the first request, which is
+// always true for plugins.
+func (f *AdaptiveLimitPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg AdaptiveLimitConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	if err := cfg.normalize(); err != nil {
+		return err
+	}
+
+	adaptiveLimit.mu.Lock()
+	adaptiveLimit.cfg = cfg
+	adaptiveLimit.mu.Unlock()
+	log.Infof("[%s] algorithm: %s, initial limit: %d", name, cfg.Algorithm, cfg.InitialLimit)
+	return nil
+}
+
+func init() {
+	_ = adaptiveLimit.cfg.normalize()
+	plugin.Register("adaptive_limit", &AdaptiveLimitPluginFactory{})
+	filter.Register("adaptive_limit", AdaptiveLimitFilter, nil)
+	admin.HandleFunc("/cmds/adaptive_limit", handleAdaptiveLimit)
+}
@@ -0,0 +1,163 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// BulkheadConfig is the `plugins.filter.bulkhead` section of trpc_go.yaml. Methods not
+// listed are not limited.
+//
+//	plugins:
+//	  filter:
+//	    bulkhead:
+//	      methods:
+//	        /trpc.helloworld.Greeter/Hello:
+//	          max_concurrent: 50
+//	          max_queue: 100
+//	          queue_timeout: 50ms
+type BulkheadConfig struct {
+	Methods map[string]BulkheadMethodConfig `yaml:"methods"` // RPC name => config
+}
+
+// BulkheadMethodConfig configures the bulkhead of one method.
+type BulkheadMethodConfig struct {
+	MaxConcurrent int           `yaml:"max_concurrent"` // requests handled at the same time, default 10
+	MaxQueue      int64         `yaml:"max_queue"`      // requests waiting for a slot, 0 rejects at once
+	QueueTimeout  time.Duration `yaml:"queue_timeout"`  // max wait for a slot, default 100ms
+}
+
+// Bulkhead bounds the concurrency of one method, with a bounded wait queue. It reports
+// the bulkhead.<rpc>.{inflight,queued,utilization} gauges and the
+// bulkhead.<rpc>.{rejected,queue_timeout} counters.
+type Bulkhead struct {
+	name    string
+	cfg     BulkheadMethodConfig
+	slots   chan struct{}
+	queued  int64
+	metrics struct {
+		inflight, queued, utilization metrics.IGauge
+		rejected, timeout             metrics.ICounter
+	}
+}
+
+// NewBulkhead creates the bulkhead of the RPC name, zero fields of cfg use the defaults.
+func NewBulkhead(name string, cfg BulkheadMethodConfig) *Bulkhead {
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = 10
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = 100 * time.Millisecond
+	}
+	b := &Bulkhead{name: name, cfg: cfg, slots: make(chan struct{}, cfg.MaxConcurrent)}
+	b.metrics.inflight = metrics.Gauge("bulkhead." + name + ".inflight")
+	b.metrics.queued = metrics.Gauge("bulkhead." + name + ".queued")
+	b.metrics.utilization = metrics.Gauge("bulkhead." + name + ".utilization")
+	b.metrics.rejected = metrics.Counter("bulkhead." + name + ".rejected")
+	b.metrics.timeout = metrics.Counter("bulkhead." + name + ".queue_timeout")
+	return b
+}
+
+// Acquire takes a slot, waiting in the queue if there is room. The returned error
+// is nil if the caller must call Release.
+func (b *Bulkhead) Acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		b.report()
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&b.queued, 1) > b.cfg.MaxQueue {
+		atomic.AddInt64(&b.queued, -1)
+		b.metrics.rejected.Incr()
+		return errs.NewFrameError(errs.RetServerOverload, fmt.Sprintf("bulkhead: %s is full", b.name))
+	}
+	b.metrics.queued.Set(float64(atomic.LoadInt64(&b.queued)))
+	defer func() {
+		b.metrics.queued.Set(float64(atomic.AddInt64(&b.queued, -1)))
+	}()
+
+	timer := time.NewTimer(b.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		b.report()
+		return nil
+	case <-timer.C:
+		b.metrics.timeout.Incr()
+		return errs.NewFrameError(errs.RetServerOverload,
+			fmt.Sprintf("bulkhead: %s queued over %s", b.name, b.cfg.QueueTimeout))
+	case <-ctx.Done():
+		b.metrics.timeout.Incr()
+		return errs.NewFrameError(errs.RetServerTimeout, "bulkhead: "+ctx.Err().Error())
+	}
+}
+
+// Release returns the slot taken by Acquire.
+func (b *Bulkhead) Release() {
+	<-b.slots
+	b.report()
+}
+
+func (b *Bulkhead) report() {
+	inflight := len(b.slots)
+	b.metrics.inflight.Set(float64(inflight))
+	b.metrics.utilization.Set(float64(inflight) / float64(cap(b.slots)))
+}
+
+var bulkheads atomic.Value // map[string]*Bulkhead, RPC name => bulkhead
+
+func init() {
+	bulkheads.Store(map[string]*Bulkhead{})
+}
+
+// BulkheadFilter isolates the configured methods from each other, so that a slow method
+// cannot take every handler goroutine of the service.
+func BulkheadFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	b, ok := bulkheads.Load().(map[string]*Bulkhead)[codec.Message(ctx).ServerRPCName()]
+	if !ok {
+		return next(ctx, req)
+	}
+	if err := b.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer b.Release()
+
+	return next(ctx, req)
+}
+
+// BulkheadPluginFactory creates the bulkheads of BulkheadFilter.
+type BulkheadPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *BulkheadPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and creates the bulkheads.
+func (f *BulkheadPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg BulkheadConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	m := make(map[string]*Bulkhead, len(cfg.Methods))
+	for rpc, mc := range cfg.Methods {
+		m[rpc] = NewBulkhead(rpc, mc)
+	}
+	bulkheads.Store(m)
+	return nil
+}
+
+func init() {
+	plugin.Register("bulkhead", &BulkheadPluginFactory{})
+	filter.Register("bulkhead", BulkheadFilter, nil)
+}
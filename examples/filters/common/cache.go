@@ -0,0 +1,420 @@
+package common
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+	"trpc.group/trpc-go/trpc-go/admin"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// Eviction policies of the response cache.
+const (
+	PolicyLRU = "lru" // evicts the least recently used entry
+	PolicyLFU = "lfu" // evicts the least frequently used entry, the least recently used among equals
+)
+
+// CacheConfig is the `plugins.filter.cache` section of trpc_go.yaml. Only the listed
+// methods are cached, they should be read-only.
+//
+//	plugins:
+//	  filter:
+//	    cache:
+//	      methods:
+//	        /trpc.helloworld.Greeter/Hello:
+//	          ttl: 30s
+//	          max_entries: 1000
+//	          policy: lfu
+//	          vary_metadata: [authorization]
+type CacheConfig struct {
+	Methods map[string]CacheMethodConfig `yaml:"methods"` // RPC name => config
+}
+
+// CacheMethodConfig configures the cache of one method. The key is the request alone: a
+// response depending on the caller, e.g. on its credentials, is served to the other callers
+// unless the metadata it depends on is listed in VaryMetadata.
+type CacheMethodConfig struct {
+	TTL          time.Duration `yaml:"ttl"`           // default 1m
+	MaxEntries   int           `yaml:"max_entries"`   // default 1000
+	Policy       string        `yaml:"policy"`        // lru or lfu, default lru
+	VaryMetadata []string      `yaml:"vary_metadata"` // metadata keys added to the key, e.g. authorization
+}
+
+func (c *CacheMethodConfig) normalize() error {
+	if c.TTL <= 0 {
+		c.TTL = time.Minute
+	}
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = 1000
+	}
+	switch c.Policy {
+	case "":
+		c.Policy = PolicyLRU
+	case PolicyLRU, PolicyLFU:
+	default:
+		return fmt.Errorf("unknown policy %q", c.Policy)
+	}
+	return nil
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	key      string
+	rsp      interface{}
+	created  time.Time
+	expire   time.Time
+	lastUsed time.Time
+	hits     int64
+	bucket   int64         // of the eviction list holding elem
+	elem     *list.Element // value is the entry
+}
+
+// CacheEntryStatus is the admin view of a cached response.
+type CacheEntryStatus struct {
+	Key      string    `json:"key"`
+	Hits     int64     `json:"hits"`
+	Created  time.Time `json:"created"`
+	Expire   time.Time `json:"expire"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// ResponseCache caches the responses of one method. Concurrent misses of one key
+// run the handler once.
+//
+// Entries are ordered for eviction in O(1) by buckets of entries, the most recently used in
+// front: LRU keeps every entry in bucket 0, LFU moves an entry to the bucket of its hits and
+// evicts from the lowest bucket. Expired entries are dropped when they are looked up or evicted.
+type ResponseCache struct {
+	cfg     CacheMethodConfig
+	flights singleflight.Group
+
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	buckets   map[int64]*list.List // bucket => entries, without empty lists
+	minBucket int64                // lowest bucket, unless emptied by a removal
+}
+
+// NewResponseCache creates a ResponseCache, zero fields of cfg use the defaults.
+func NewResponseCache(cfg CacheMethodConfig) (*ResponseCache, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	return &ResponseCache{
+		cfg:     cfg,
+		entries: make(map[string]*cacheEntry),
+		buckets: make(map[int64]*list.List),
+	}, nil
+}
+
+// get returns the response cached for key.
+func (c *ResponseCache) get(key string, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(e.expire) {
+		c.removeLocked(e)
+		return nil, false
+	}
+	e.hits++
+	e.lastUsed = now
+	if c.cfg.Policy == PolicyLFU {
+		c.unlinkLocked(e)
+		c.pushLocked(e, e.hits)
+	} else {
+		c.buckets[e.bucket].MoveToFront(e.elem)
+	}
+	return e.rsp, true
+}
+
+// set caches rsp, evicting an entry if the cache is full.
+func (c *ResponseCache) set(key string, rsp interface{}, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeLocked(e)
+	} else if len(c.entries) >= c.cfg.MaxEntries {
+		c.evictLocked()
+	}
+	e := &cacheEntry{key: key, rsp: rsp, created: now, expire: now.Add(c.cfg.TTL), lastUsed: now}
+	c.entries[key] = e
+	c.pushLocked(e, 0)
+	c.minBucket = 0
+}
+
+// pushLocked adds e in front of bucket.
+func (c *ResponseCache) pushLocked(e *cacheEntry, bucket int64) {
+	l, ok := c.buckets[bucket]
+	if !ok {
+		l = list.New()
+		c.buckets[bucket] = l
+	}
+	e.bucket, e.elem = bucket, l.PushFront(e)
+}
+
+// unlinkLocked takes e out of its bucket, which the next bucket replaces as the lowest one
+// when it empties: only a hit of LFU unlinks without removing.
+func (c *ResponseCache) unlinkLocked(e *cacheEntry) {
+	l := c.buckets[e.bucket]
+	l.Remove(e.elem)
+	if l.Len() == 0 {
+		delete(c.buckets, e.bucket)
+		if e.bucket == c.minBucket {
+			c.minBucket++
+		}
+	}
+}
+
+// removeLocked drops e from the cache.
+func (c *ResponseCache) removeLocked(e *cacheEntry) {
+	c.unlinkLocked(e)
+	delete(c.entries, e.key)
+}
+
+// evictLocked drops the least recently used entry of the lowest bucket.
+func (c *ResponseCache) evictLocked() {
+	l, ok := c.buckets[c.minBucket]
+	if !ok {
+		// Emptied by an expiry or a purge, rare enough to look for the lowest bucket.
+		first := true
+		for b := range c.buckets {
+			if first || b < c.minBucket {
+				c.minBucket, first = b, false
+			}
+		}
+		if l, ok = c.buckets[c.minBucket]; !ok {
+			return
+		}
+	}
+	c.removeLocked(l.Back().Value.(*cacheEntry))
+}
+
+// Do returns the cached response of key, or runs handle and caches its response if it succeeds.
+// Concurrent calls of key share one run of handle, with the ctx of the first one: when that
+// ctx is done and handle fails, the others run handle again instead of sharing the error.
+func (c *ResponseCache) Do(ctx context.Context, key string, handle func() (interface{}, error)) (interface{}, error) {
+	for {
+		if rsp, ok := c.get(key, time.Now()); ok {
+			return cloneResponse(rsp), nil
+		}
+		var led bool // set only in the goroutine running handle
+		rsp, err, shared := c.flights.Do(key, func() (rsp interface{}, err error) {
+			led = true
+			defer func() {
+				if v := recover(); v != nil {
+					err = &flightPanic{p: capturePanic(v)}
+				}
+			}()
+			if rsp, err = handle(); err == nil {
+				c.set(key, cloneResponse(rsp), time.Now())
+			} else if ctx.Err() != nil {
+				err = &flightCanceled{err: err}
+			}
+			return rsp, err
+		})
+		if p, ok := err.(*flightPanic); ok {
+			// Raise it with the stack of the handler, lost once out of singleflight.
+			panic(p.p)
+		}
+		if f, ok := err.(*flightCanceled); ok {
+			if !led && ctx.Err() == nil {
+				continue
+			}
+			err = f.err
+		}
+		if shared {
+			rsp = cloneResponse(rsp)
+		}
+		return rsp, err
+	}
+}
+
+// flightCanceled is the error of a handler whose ctx was done, not shared with the other calls.
+type flightCanceled struct {
+	err error
+}
+
+func (f *flightCanceled) Error() string {
+	return f.err.Error()
+}
+
+// flightPanic carries a panic of the handler out of singleflight.
+type flightPanic struct {
+	p *capturedPanic
+}
+
+func (p *flightPanic) Error() string {
+	return fmt.Sprint("panic: ", p.p.value)
+}
+
+// Entries returns the live entries, keys are the request hashes.
+func (c *ResponseCache) Entries() []CacheEntryStatus {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]CacheEntryStatus, 0, len(c.entries))
+	for key, e := range c.entries {
+		if now.After(e.expire) {
+			continue
+		}
+		entries = append(entries, CacheEntryStatus{
+			Key: key, Hits: e.hits, Created: e.created, Expire: e.expire, LastUsed: e.lastUsed,
+		})
+	}
+	return entries
+}
+
+// Purge removes the entry of key, or every entry if key is empty. It returns the number removed.
+func (c *ResponseCache) Purge(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key == "" {
+		n := len(c.entries)
+		c.entries = make(map[string]*cacheEntry)
+		c.buckets = make(map[int64]*list.List)
+		c.minBucket = 0
+		return n
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		return 0
+	}
+	c.removeLocked(e)
+	return 1
+}
+
+// cloneResponse copies proto responses, so that callers modifying their response do not
+// change the cached one.
+func cloneResponse(rsp interface{}) interface{} {
+	if m, ok := rsp.(proto.Message); ok && m != nil {
+		return proto.Clone(m)
+	}
+	return rsp
+}
+
+var responseCaches atomic.Value // map[string]*ResponseCache, RPC name => cache
+
+func init() {
+	responseCaches.Store(map[string]*ResponseCache{})
+}
+
+// CacheFilter serves the configured methods from the cache, the key is the hash of the
+// serialized request and of the vary_metadata metadata.
+func CacheFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (interface{}, error) {
+	msg := codec.Message(ctx)
+	c, ok := responseCaches.Load().(map[string]*ResponseCache)[msg.ServerRPCName()]
+	if !ok {
+		return next(ctx, req)
+	}
+
+	var body []byte
+	var err error
+	if m, ok := req.(proto.Message); ok {
+		// Map fields are encoded in random order unless deterministic.
+		body, err = proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	} else {
+		body, err = codec.Marshal(msg.SerializationType(), req)
+	}
+	if err != nil {
+		return nil, errs.WrapFrameError(err, errs.RetServerDecodeFail, "cache: marshal request")
+	}
+	h := sha256.New()
+	_, _ = h.Write(body)
+	md := msg.ServerMetaData()
+	for _, k := range c.cfg.VaryMetadata {
+		v, ok := md[k]
+		if !ok {
+			// Tells a missing key from an empty value.
+			_, _ = h.Write([]byte{0})
+			continue
+		}
+		_, _ = h.Write([]byte{1})
+		_ = binary.Write(h, binary.BigEndian, uint32(len(v)))
+		_, _ = h.Write(v)
+	}
+	return c.Do(ctx, hex.EncodeToString(h.Sum(nil)), func() (interface{}, error) {
+		return next(ctx, req)
+	})
+}
+
+// handleCache shows the cached entries by "http://ip:port/cmds/cache?method=xxx", and purges
+// them by DELETE, with an optional key parameter.
+func handleCache(w http.ResponseWriter, r *http.Request) {
+	caches := responseCaches.Load().(map[string]*ResponseCache)
+	method := r.URL.Query().Get("method")
+	if method != "" {
+		if _, ok := caches[method]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": "method is not cached: " + method})
+			return
+		}
+		caches = map[string]*ResponseCache{method: caches[method]}
+	}
+
+	if r.Method == http.MethodDelete {
+		purged := 0
+		for _, c := range caches {
+			purged += c.Purge(r.URL.Query().Get("key"))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"purged": purged})
+		return
+	}
+
+	entries := make(map[string][]CacheEntryStatus, len(caches))
+	for name, c := range caches {
+		entries[name] = c.Entries()
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"methods": entries})
+}
+
+// CachePluginFactory configures the cached methods of CacheFilter.
+type CachePluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *CachePluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and creates the caches.
+func (f *CachePluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg CacheConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	caches := make(map[string]*ResponseCache, len(cfg.Methods))
+	for rpc, mc := range cfg.Methods {
+		c, err := NewResponseCache(mc)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", name, rpc, err)
+		}
+		caches[rpc] = c
+	}
+	responseCaches.Store(caches)
+	return nil
+}
+
+func init() {
+	plugin.Register("cache", &CachePluginFactory{})
+	filter.Register("cache", CacheFilter, nil)
+	admin.HandleFunc("/cmds/cache", handleCache)
+}
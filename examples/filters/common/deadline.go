@@ -0,0 +1,172 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// DeadlineShedConfig is the `plugins.filter.deadline_shed` section of trpc_go.yaml.
+// A request is shed when its remaining deadline is shorter than mean + deviations * deviation
+// of the recent latencies of its RPC.
+type DeadlineShedConfig struct {
+	Alpha         float64       `yaml:"alpha"`          // weight of a new sample in the moving averages, default 0.1
+	Deviations    float64       `yaml:"deviations"`     // default 2
+	MinSamples    int64         `yaml:"min_samples"`    // samples needed before shedding, default 20
+	ProbeInterval time.Duration `yaml:"probe_interval"` // a request to shed is let through once per interval, default 1s
+}
+
+func (c *DeadlineShedConfig) normalize() {
+	if c.Alpha <= 0 || c.Alpha > 1 {
+		c.Alpha = 0.1
+	}
+	if c.Deviations <= 0 {
+		c.Deviations = 2
+	}
+	if c.MinSamples <= 0 {
+		c.MinSamples = 20
+	}
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = time.Second
+	}
+}
+
+// latencyEstimator keeps moving averages of the latency and of its deviation,
+// the way TCP estimates the round trip time.
+type latencyEstimator struct {
+	mu        sync.Mutex
+	samples   int64
+	mean      float64 // ns
+	dev       float64 // ns
+	lastProbe time.Time
+}
+
+func (e *latencyEstimator) observe(d time.Duration, alpha float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	x := float64(d)
+	if e.samples == 0 {
+		e.mean, e.dev = x, x/2
+	} else {
+		e.dev += alpha * (math.Abs(x-e.mean) - e.dev)
+		e.mean += alpha * (x - e.mean)
+	}
+	e.samples++
+}
+
+// estimate returns the expected latency, false while there are too few samples.
+func (e *latencyEstimator) estimate(cfg *DeadlineShedConfig) (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.samples < cfg.MinSamples {
+		return 0, false
+	}
+	return time.Duration(e.mean + cfg.Deviations*e.dev), true
+}
+
+// probe reports whether a request to shed should be let through, once per interval. Only the
+// admitted requests are sampled: without probes, an estimate raised above the deadlines of the
+// clients by a latency spike would shed every request and never come down.
+func (e *latencyEstimator) probe(now time.Time, interval time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if now.Sub(e.lastProbe) < interval {
+		return false
+	}
+	e.lastProbe = now
+	return true
+}
+
+// deadlineShedder holds the config and the estimators of every RPC.
+type deadlineShedder struct {
+	cfg        DeadlineShedConfig
+	estimators sync.Map // RPC name => *latencyEstimator
+}
+
+func newDeadlineShedder(cfg DeadlineShedConfig) *deadlineShedder {
+	cfg.normalize()
+	return &deadlineShedder{cfg: cfg}
+}
+
+func (s *deadlineShedder) estimator(rpc string) *latencyEstimator {
+	if e, ok := s.estimators.Load(rpc); ok {
+		return e.(*latencyEstimator)
+	}
+	e, _ := s.estimators.LoadOrStore(rpc, &latencyEstimator{})
+	return e.(*latencyEstimator)
+}
+
+var defaultDeadlineShedder atomic.Value // *deadlineShedder
+
+func init() {
+	defaultDeadlineShedder.Store(newDeadlineShedder(DeadlineShedConfig{}))
+}
+
+// DeadlineShedFilter rejects the requests which cannot finish before their deadline,
+// according to the recent latencies of the RPC. Shed requests are counted by the
+// deadline_shed.<rpc> counter. Requests without deadline are never shed, and one request
+// to shed per probe_interval is handled to sample the latency again.
+func DeadlineShedFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	s := defaultDeadlineShedder.Load().(*deadlineShedder)
+	rpc := codec.Message(ctx).ServerRPCName()
+	e := s.estimator(rpc)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			metrics.Counter("deadline_shed." + rpc).Incr()
+			return nil, errs.NewFrameError(errs.RetServerFullLinkTimeout,
+				"deadline_shed: deadline exceeded before handling")
+		}
+		if expected, ok := e.estimate(&s.cfg); ok && remaining < expected && !e.probe(time.Now(), s.cfg.ProbeInterval) {
+			metrics.Counter("deadline_shed." + rpc).Incr()
+			log.DebugContextf(ctx, "[DEADLINE] Shed RPC: %s, Remaining: %s, Expected: %s", rpc, remaining, expected)
+			return nil, errs.NewFrameError(errs.RetServerTimeout,
+				fmt.Sprintf("deadline_shed: %s left, %s expected", remaining, expected))
+		}
+	}
+
+	start := time.Now()
+	rsp, err = next(ctx, req)
+	// Failures are often faster or slower than real work, they would skew the estimate.
+	if err == nil {
+		e.observe(time.Since(start), s.cfg.Alpha)
+	}
+	return rsp, err
+}
+
+// DeadlineShedPluginFactory configures the latency estimation of DeadlineShedFilter.
+type DeadlineShedPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *DeadlineShedPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the shedder.
+func (f *DeadlineShedPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg DeadlineShedConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	defaultDeadlineShedder.Store(newDeadlineShedder(cfg))
+	return nil
+}
+
+func init() {
+	plugin.Register("deadline_shed", &DeadlineShedPluginFactory{})
+	filter.Register("deadline_shed", DeadlineShedFilter, nil)
+}
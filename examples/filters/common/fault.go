@@ -0,0 +1,236 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/admin"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// MetaFaultTag is the metadata key matched by FaultRule.Tag.
+const MetaFaultTag = "x-fault-tag"
+
+// defaultFaultTTL applies to the rules added without ttl, so a forgotten rule cannot
+// break a service for long.
+const defaultFaultTTL = 5 * time.Minute
+
+// FaultRule injects a fault into the matching requests. Empty match fields match everything.
+type FaultRule struct {
+	ID      string        `json:"id"`
+	Method  string        `json:"method,omitempty"`
+	Caller  string        `json:"caller,omitempty"`
+	Tag     string        `json:"tag,omitempty"` // value of the x-fault-tag metadata
+	Percent float64       `json:"percent"`       // ratio of the matching requests affected, 0-100
+	Delay   time.Duration `json:"delay,omitempty"`
+	Abort   int           `json:"abort,omitempty"` // errs code returned, after the delay
+	Panic   bool          `json:"panic,omitempty"` // panics instead of aborting
+	Expire  time.Time     `json:"expire"`
+}
+
+// MarshalJSON writes the delay like the POST body of /cmds/faults, e.g. "200ms".
+func (r FaultRule) MarshalJSON() ([]byte, error) {
+	type rule FaultRule
+	var delay string
+	if r.Delay > 0 {
+		delay = r.Delay.String()
+	}
+	return json.Marshal(struct {
+		rule
+		Delay string `json:"delay,omitempty"`
+	}{rule(r), delay})
+}
+
+func (r *FaultRule) match(method, caller string, md codec.MetaData) bool {
+	return (r.Method == "" || r.Method == method) &&
+		(r.Caller == "" || r.Caller == caller) &&
+		(r.Tag == "" || r.Tag == string(md[MetaFaultTag]))
+}
+
+// faultRules are the rules added by /cmds/faults.
+var faultRules = struct {
+	mu    sync.RWMutex
+	rules []*FaultRule
+}{}
+
+// addFaultRule adds r, drops the expired rules and returns the id of r.
+func addFaultRule(r *FaultRule, now time.Time) string {
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+	r.ID = hex.EncodeToString(id)
+
+	faultRules.mu.Lock()
+	defer faultRules.mu.Unlock()
+
+	rules := make([]*FaultRule, 0, len(faultRules.rules)+1)
+	for _, old := range faultRules.rules {
+		if !now.After(old.Expire) {
+			rules = append(rules, old)
+		}
+	}
+	faultRules.rules = append(rules, r)
+	return r.ID
+}
+
+// removeFaultRules removes the rule id, or every rule if id is empty, and the expired ones.
+// It returns the number of rules removed.
+func removeFaultRules(id string, now time.Time) int {
+	faultRules.mu.Lock()
+	defer faultRules.mu.Unlock()
+
+	var (
+		kept    []*FaultRule
+		removed int
+	)
+	for _, r := range faultRules.rules {
+		if now.After(r.Expire) {
+			continue
+		}
+		if id == "" || r.ID == id {
+			removed++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	faultRules.rules = kept
+	return removed
+}
+
+// activeFaultRules returns the rules not expired.
+func activeFaultRules(now time.Time) []FaultRule {
+	faultRules.mu.RLock()
+	defer faultRules.mu.RUnlock()
+
+	rules := make([]FaultRule, 0, len(faultRules.rules))
+	for _, r := range faultRules.rules {
+		if !now.After(r.Expire) {
+			rules = append(rules, *r)
+		}
+	}
+	return rules
+}
+
+// FaultFilter injects the faults added through /cmds/faults, the first matching rule applies.
+func FaultFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	msg := codec.Message(ctx)
+	now := time.Now()
+
+	var rule *FaultRule
+	faultRules.mu.RLock()
+	for _, r := range faultRules.rules {
+		if !now.After(r.Expire) && r.match(msg.ServerRPCName(), msg.CallerServiceName(), msg.ServerMetaData()) {
+			rule = r
+			break
+		}
+	}
+	faultRules.mu.RUnlock()
+	if rule == nil || mrand.Float64()*100 >= rule.Percent {
+		return next(ctx, req)
+	}
+
+	log.DebugContextf(ctx, "[FAULT] Injected Rule: %s, RPC: %s", rule.ID, msg.ServerRPCName())
+	if rule.Delay > 0 {
+		timer := time.NewTimer(rule.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errs.NewFrameError(errs.RetServerTimeout, "fault: "+ctx.Err().Error())
+		}
+	}
+	if rule.Panic {
+		panic("fault: injected by rule " + rule.ID)
+	}
+	if rule.Abort != 0 {
+		return nil, errs.New(rule.Abort, "fault: injected by rule "+rule.ID)
+	}
+	return next(ctx, req)
+}
+
+// faultRuleRequest is the body of POST /cmds/faults, durations are strings like "200ms".
+type faultRuleRequest struct {
+	Method  string  `json:"method"`
+	Caller  string  `json:"caller"`
+	Tag     string  `json:"tag"`
+	Percent float64 `json:"percent"`
+	Delay   string  `json:"delay"`
+	Abort   int     `json:"abort"`
+	Panic   bool    `json:"panic"`
+	TTL     string  `json:"ttl"` // default 5m
+}
+
+func (req *faultRuleRequest) rule(now time.Time) (*FaultRule, error) {
+	if req.Percent <= 0 || req.Percent > 100 {
+		return nil, fmt.Errorf("percent must be in (0, 100]")
+	}
+	r := &FaultRule{
+		Method: req.Method, Caller: req.Caller, Tag: req.Tag,
+		Percent: req.Percent, Abort: req.Abort, Panic: req.Panic,
+	}
+	if req.Delay != "" {
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("delay: %w", err)
+		}
+		r.Delay = d
+	}
+	if r.Delay <= 0 && r.Abort == 0 && !r.Panic {
+		return nil, fmt.Errorf("one of delay, abort and panic is required")
+	}
+	ttl := defaultFaultTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid ttl %q", req.TTL)
+		}
+		ttl = d
+	}
+	r.Expire = now.Add(ttl)
+	return r, nil
+}
+
+// handleFaults manages the fault rules by "http://ip:port/cmds/faults":
+//
+//	GET                                  lists the active rules
+//	POST {"method": "...", "percent": 50, "delay": "200ms", "abort": 22, "ttl": "1m"}
+//	DELETE ?id=xxx                       removes a rule, or every rule without id
+func handleFaults(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	switch r.Method {
+	case http.MethodPost:
+		var req faultRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		rule, err := req.rule(now)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": addFaultRule(rule, now)})
+	case http.MethodDelete:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"removed": removeFaultRules(r.URL.Query().Get("id"), now),
+		})
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"faults": activeFaultRules(now)})
+	}
+}
+
+func init() {
+	filter.Register("fault", FaultFilter, nil)
+	admin.HandleFunc("/cmds/faults", handleFaults)
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	thttp "trpc.group/trpc-go/trpc-go/http"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// Where the idempotency key is read from.
+const (
+	MetaIdempotencyKey   = "x-idempotency-key"
+	HeaderIdempotencyKey = "Idempotency-Key"
+)
+
+// RetIdempotencyConflict is returned for a key reused with another request, HTTP 409.
+const RetIdempotencyConflict = 10409
+
+// IdempotencyConfig is the `plugins.filter.idempotency` section of trpc_go.yaml.
+type IdempotencyConfig struct {
+	TTL        time.Duration `yaml:"ttl"`         // how long a response is replayed, default 10m
+	MaxEntries int           `yaml:"max_entries"` // the oldest keys are evicted beyond it, default 10000
+}
+
+// idempotentCall is the first execution of a key. done is closed once rsp and err are set.
+type idempotentCall struct {
+	key    string
+	digest string // of the request, a duplicate must carry the same request
+	done   chan struct{}
+	rsp    interface{}
+	err    error
+	expire time.Time
+	elem   *list.Element
+}
+
+// IdempotencyCache runs the handler once per key and keeps the successful responses.
+// Failed calls are forgotten once finished, so the client can retry them.
+type IdempotencyCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	calls map[string]*idempotentCall
+	order *list.List // keys by insertion, the oldest first
+}
+
+// NewIdempotencyCache creates an IdempotencyCache from cfg, zero fields use the defaults.
+func NewIdempotencyCache(cfg IdempotencyConfig) *IdempotencyCache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 10 * time.Minute
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10000
+	}
+	return &IdempotencyCache{
+		ttl:   cfg.TTL,
+		max:   cfg.MaxEntries,
+		calls: make(map[string]*idempotentCall),
+		order: list.New(),
+	}
+}
+
+// Do returns the response of the first call of key, running handle if there is none.
+// A duplicate arriving while the first call runs waits for it, or for ctx to be done.
+// A duplicate whose request digest differs fails with RetIdempotencyConflict.
+func (c *IdempotencyCache) Do(ctx context.Context, key, digest string,
+	handle func() (interface{}, error)) (rsp interface{}, replayed bool, err error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	call, ok := c.calls[key]
+	if ok && call.elem != nil && now.After(call.expire) {
+		c.removeLocked(call)
+		ok = false
+	}
+	if ok {
+		c.mu.Unlock()
+		if call.digest != digest {
+			return nil, false, errs.New(RetIdempotencyConflict,
+				"idempotency: key reused with another request")
+		}
+		select {
+		case <-call.done:
+			return replay(call.rsp), true, call.err
+		case <-ctx.Done():
+			return nil, true, errs.NewFrameError(errs.RetServerTimeout,
+				"idempotency: wait for the first call: "+ctx.Err().Error())
+		}
+	}
+	call = &idempotentCall{key: key, digest: digest, done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	var finished bool
+	defer func() {
+		if !finished {
+			// handle panicked, the waiting duplicates fail and the key is released.
+			call.err = errs.NewFrameError(errs.RetServerSystemErr, "idempotency: first call panicked")
+		}
+		c.mu.Lock()
+		if call.err != nil {
+			delete(c.calls, key)
+		} else {
+			call.expire = time.Now().Add(c.ttl)
+			call.elem = c.order.PushBack(call)
+			for c.order.Len() > c.max {
+				c.removeLocked(c.order.Front().Value.(*idempotentCall))
+			}
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.rsp, call.err = handle()
+	finished = true
+	return call.rsp, false, call.err
+}
+
+// removeLocked drops a finished call.
+func (c *IdempotencyCache) removeLocked(call *idempotentCall) {
+	c.order.Remove(call.elem)
+	if c.calls[call.key] == call {
+		delete(c.calls, call.key)
+	}
+}
+
+// replay copies proto responses, so that a filter modifying the response of one
+// request does not change the others.
+func replay(rsp interface{}) interface{} {
+	if m, ok := rsp.(proto.Message); ok && m != nil {
+		return proto.Clone(m)
+	}
+	return rsp
+}
+
+var defaultIdempotencyCache atomic.Value // *IdempotencyCache
+
+func init() {
+	defaultIdempotencyCache.Store(NewIdempotencyCache(IdempotencyConfig{}))
+}
+
+// idempotencyKey reads the Idempotency-Key header of HTTP requests, or the
+// x-idempotency-key metadata of trpc requests.
+func idempotencyKey(ctx context.Context) string {
+	if head := thttp.Head(ctx); head != nil && head.Request != nil {
+		return head.Request.Header.Get(HeaderIdempotencyKey)
+	}
+	return string(codec.Message(ctx).ServerMetaData()[MetaIdempotencyKey])
+}
+
+// idempotencyScope returns who the keys belong to: the subject authenticated by JWTAuthFilter,
+// so that clients cannot read each other's responses. Without it, the caller service name is
+// declared by the client and only keeps apart the keys of well-behaved clients.
+func idempotencyScope(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return "sub:" + claims.Subject()
+	}
+	return "caller:" + codec.Message(ctx).CallerServiceName()
+}
+
+// IdempotencyFilter replays the response of the first request for duplicates carrying
+// the same idempotency key. Requests without a key are not affected. The filter must come
+// after jwt in the chain for the keys to be scoped by the authenticated subject.
+func IdempotencyFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (interface{}, error) {
+	key := idempotencyKey(ctx)
+	if key == "" {
+		return next(ctx, req)
+	}
+
+	msg := codec.Message(ctx)
+	body, err := signedBody(msg.SerializationType(), req)
+	if err != nil {
+		return nil, errs.WrapFrameError(err, errs.RetServerEncodeFail, "idempotency: marshal request")
+	}
+	digest := sha256.Sum256(body)
+
+	cache := defaultIdempotencyCache.Load().(*IdempotencyCache)
+	rsp, replayed, err := cache.Do(ctx, idempotencyScope(ctx)+"|"+msg.ServerRPCName()+"|"+key,
+		string(digest[:]), func() (interface{}, error) { return next(ctx, req) })
+	if replayed {
+		log.DebugContextf(ctx, "[IDEMPOTENCY] Replayed Key: %s, RPC: %s", key, msg.ServerRPCName())
+	}
+	return rsp, err
+}
+
+// IdempotencyPluginFactory configures the cache of IdempotencyFilter.
+type IdempotencyPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *IdempotencyPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the cache.
+func (f *IdempotencyPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg IdempotencyConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	defaultIdempotencyCache.Store(NewIdempotencyCache(cfg))
+	return nil
+}
+
+func init() {
+	thttp.RegisterStatus(RetIdempotencyConflict, http.StatusConflict)
+	plugin.Register("idempotency", &IdempotencyPluginFactory{})
+	filter.Register("idempotency", IdempotencyFilter, nil)
+}
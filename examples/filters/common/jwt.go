@@ -0,0 +1,374 @@
+package common
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	thttp "trpc.group/trpc-go/trpc-go/http"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// Error codes returned by JWTAuthFilter, all of them are mapped to 401 by the HTTP layer.
+const (
+	RetTokenMissing = 10401 // no bearer token in metadata or header
+	RetTokenInvalid = 10402 // malformed token, unknown key or bad signature
+	RetTokenExpired = 10403 // exp or nbf check failed
+	RetTokenClaims  = 10404 // issuer or audience mismatch
+)
+
+// JWTConfig is the `plugins.filter.jwt` section of trpc_go.yaml:
+//
+//	plugins:
+//	  filter:
+//	    jwt:
+//	      jwks_file: ./jwks.json
+//	      issuer: trpc-go-note
+//	      audience: trpc.helloworld.Greeter
+//	      leeway: 30s
+type JWTConfig struct {
+	JWKSFile   string        `yaml:"jwks_file"`  // local JWKS file, re-read when written
+	Issuer     string        `yaml:"issuer"`     // required "iss" if not empty
+	Audience   string        `yaml:"audience"`   // required "aud" if not empty
+	Leeway     time.Duration `yaml:"leeway"`     // clock skew allowed for exp and nbf
+	Algorithms []string      `yaml:"algorithms"` // accepted algorithms, defaults to HS256, RS256 and ES256
+}
+
+// Claims is the payload of a verified JWT.
+type Claims map[string]interface{}
+
+// Subject returns the "sub" claim.
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims verified by JWTAuthFilter.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(Claims)
+	return c, ok
+}
+
+// jwk is a JSON Web Key, only the fields of oct, RSA and EC P-256 keys are kept.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a parsed jwk.
+type verificationKey struct {
+	kid string
+	alg string      // HS256, RS256 or ES256
+	key interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+func (k *jwk) parse() (*verificationKey, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad k: %w", k.Kid, err)
+		}
+		return &verificationKey{kid: k.Kid, alg: "HS256", key: secret}, nil
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad n: %w", k.Kid, err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad e: %w", k.Kid, err)
+		}
+		return &verificationKey{kid: k.Kid, alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("key %s: unsupported curve %s", k.Kid, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad x: %w", k.Kid, err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: bad y: %w", k.Kid, err)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %s: point is not on curve", k.Kid)
+		}
+		return &verificationKey{kid: k.Kid, alg: "ES256", key: pub}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported kty %s", k.Kid, k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verify checks sig against signed with the key.
+func (k *verificationKey) verify(signed, sig []byte) bool {
+	sum := sha256.Sum256(signed)
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes an ES256 signature as R || S, 32 bytes each.
+		if len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, sum[:], r, s)
+	}
+	return false
+}
+
+// parseJWKS parses a JWKS document, keys which cannot be parsed are skipped with a warning.
+func parseJWKS(data []byte) ([]*verificationKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwt: parse jwks: %w", err)
+	}
+	keys := make([]*verificationKey, 0, len(set.Keys))
+	for i := range set.Keys {
+		k, err := set.Keys[i].parse()
+		if err != nil {
+			log.Warnf("[JWT] skip %v", err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// JWTVerifier verifies compact JWS tokens against a rotating key set.
+type JWTVerifier struct {
+	cfg  JWTConfig
+	keys atomic.Value // []*verificationKey
+}
+
+// NewJWTVerifier creates a JWTVerifier without any key.
+func NewJWTVerifier(cfg JWTConfig) *JWTVerifier {
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{"HS256", "RS256", "ES256"}
+	}
+	v := &JWTVerifier{cfg: cfg}
+	v.keys.Store([]*verificationKey(nil))
+	return v
+}
+
+// SetJWKS replaces the key set, the old keys stop working immediately.
+func (v *JWTVerifier) SetJWKS(data []byte) error {
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	v.keys.Store(keys)
+	return nil
+}
+
+func (v *JWTVerifier) allowed(alg string) bool {
+	for _, a := range v.cfg.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature and registered claims of token and returns its claims.
+func (v *JWTVerifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errs.New(RetTokenInvalid, "jwt: token must have 3 parts")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errs.Wrap(err, RetTokenInvalid, "jwt: bad header")
+	}
+	if !v.allowed(header.Alg) {
+		return nil, errs.Newf(RetTokenInvalid, "jwt: algorithm %q not allowed", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errs.Wrap(err, RetTokenInvalid, "jwt: bad signature encoding")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range v.keys.Load().([]*verificationKey) {
+		// The key decides the algorithm, never trust alg alone, or an RSA public key
+		// could be used as an HMAC secret.
+		if k.alg != header.Alg || (header.Kid != "" && k.kid != header.Kid) {
+			continue
+		}
+		if k.verify(signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errs.Newf(RetTokenInvalid, "jwt: no key verifies the signature, kid: %q", header.Kid)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errs.Wrap(err, RetTokenInvalid, "jwt: bad payload")
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) checkClaims(claims Claims, now time.Time) error {
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(v.cfg.Leeway)) {
+		return errs.New(RetTokenExpired, "jwt: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errs.New(RetTokenExpired, "jwt: token not valid yet")
+	}
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return errs.Newf(RetTokenClaims, "jwt: unexpected issuer %v", claims["iss"])
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return errs.Newf(RetTokenClaims, "jwt: unexpected audience %v", claims["aud"])
+	}
+	return nil
+}
+
+// hasAudience handles "aud" being either a string or an array of strings.
+func hasAudience(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, s := range a {
+			if s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// bearerToken reads the token from the HTTP Authorization header, or from the
+// "authorization" trpc metadata for trpc requests.
+func bearerToken(ctx context.Context) string {
+	var auth string
+	if head := thttp.Head(ctx); head != nil && head.Request != nil {
+		auth = head.Request.Header.Get("Authorization")
+	} else {
+		auth = string(codec.Message(ctx).ServerMetaData()["authorization"])
+	}
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return auth
+}
+
+var defaultJWTVerifier atomic.Value // *JWTVerifier
+
+func init() {
+	defaultJWTVerifier.Store(NewJWTVerifier(JWTConfig{}))
+}
+
+// JWTAuthFilter verifies the bearer JWT of the request and puts its claims into the context,
+// handlers read them by ClaimsFromContext.
+func JWTAuthFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	token := bearerToken(ctx)
+	if token == "" {
+		return nil, errs.New(RetTokenMissing, "jwt: missing bearer token")
+	}
+
+	claims, err := defaultJWTVerifier.Load().(*JWTVerifier).Verify(token, time.Now())
+	if err != nil {
+		log.DebugContextf(ctx, "[JWT] Verify Failed: %v", err)
+		return nil, err
+	}
+	return next(context.WithValue(ctx, claimsKey{}, claims), req)
+}
+
+// JWTPluginFactory loads the JWKS file and re-reads it whenever it is written.
+type JWTPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *JWTPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup loads the keys and starts watching the JWKS file.
+func (f *JWTPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg JWTConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	if cfg.JWKSFile == "" {
+		return fmt.Errorf("%s: jwks_file is required", name)
+	}
+
+	v := NewJWTVerifier(cfg)
+	data, err := watchFile(cfg.JWKSFile, v.SetJWKS)
+	if err != nil {
+		return err
+	}
+	if err := v.SetJWKS(data); err != nil {
+		return err
+	}
+	defaultJWTVerifier.Store(v)
+	return nil
+}
+
+func init() {
+	for _, code := range []int{RetTokenMissing, RetTokenInvalid, RetTokenExpired, RetTokenClaims} {
+		thttp.RegisterStatus(code, http.StatusUnauthorized)
+	}
+	plugin.Register("jwt", &JWTPluginFactory{})
+	filter.Register("jwt", JWTAuthFilter, nil)
+}
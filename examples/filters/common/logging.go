@@ -2,29 +2,207 @@ package common
 
 import (
 	"context"
-	"fmt"
+	"math/rand"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
 	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
 )
 
-// LoggingFilter returns a server filter that logs request and response.
+// redactedValue replaces the string and bytes fields marked as sensitive.
+const redactedValue = "******"
+
+// AccessLogConfig is the `plugins.filter.logging` section of trpc_go.yaml:
+//
+//	plugins:
+//	  filter:
+//	    logging:
+//	      sample_rate: 0.1
+//	      log_payload: true
+//	      redact_fields:
+//	        - trpc.helloworld.HelloRequest.msg
+type AccessLogConfig struct {
+	// SampleRate is the ratio of successful RPCs logged, failed RPCs are always logged.
+	SampleRate *float64 `yaml:"sample_rate"` // default 1
+	// LogPayload adds the request and response, with sensitive fields masked.
+	LogPayload bool `yaml:"log_payload"`
+	// RedactFields are full names of proto fields to mask, e.g. trpc.helloworld.HelloRequest.msg.
+	// Fields declared with [debug_redact = true] in the .proto file are always masked.
+	RedactFields []string `yaml:"redact_fields"`
+}
+
+// accessLogger writes the access log records with an AccessLogConfig.
+type accessLogger struct {
+	sampleRate float64
+	logPayload bool
+	redact     map[protoreflect.FullName]bool
+}
+
+func newAccessLogger(cfg AccessLogConfig) *accessLogger {
+	l := &accessLogger{sampleRate: 1, logPayload: cfg.LogPayload, redact: make(map[protoreflect.FullName]bool)}
+	if cfg.SampleRate != nil {
+		l.sampleRate = *cfg.SampleRate
+	}
+	for _, f := range cfg.RedactFields {
+		l.redact[protoreflect.FullName(f)] = true
+	}
+	return l
+}
+
+var defaultAccessLogger atomic.Value // *accessLogger
+
+func init() {
+	defaultAccessLogger.Store(newAccessLogger(AccessLogConfig{}))
+}
+
+// sensitive reports whether the field must be masked.
+func (l *accessLogger) sensitive(fd protoreflect.FieldDescriptor) bool {
+	if l.redact[fd.FullName()] {
+		return true
+	}
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	return ok && opts.GetDebugRedact()
+}
+
+// mask masks the sensitive fields of m in place, including nested messages.
+func (l *accessLogger) mask(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if l.sensitive(fd) {
+			switch {
+			case fd.IsList() || fd.IsMap():
+				m.Clear(fd)
+			case fd.Kind() == protoreflect.StringKind:
+				m.Set(fd, protoreflect.ValueOfString(redactedValue))
+			case fd.Kind() == protoreflect.BytesKind:
+				m.Set(fd, protoreflect.ValueOfBytes([]byte(redactedValue)))
+			default:
+				m.Clear(fd)
+			}
+			return true
+		}
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				l.mask(list.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				l.mask(mv.Message())
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			l.mask(v.Message())
+		}
+		return true
+	})
+}
+
+// payload returns the masked JSON of a proto message, the original is not modified.
+func (l *accessLogger) payload(body interface{}) string {
+	m, ok := body.(proto.Message)
+	if !ok || m == nil {
+		return ""
+	}
+	masked := proto.Clone(m)
+	l.mask(masked.ProtoReflect())
+	b, err := protojson.Marshal(masked)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// payloadSize returns the serialized size of proto messages, 0 for other types.
+func payloadSize(body interface{}) int {
+	if m, ok := body.(proto.Message); ok && m != nil {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// log writes one record of an RPC, successful RPCs are sampled.
+func (l *accessLogger) log(ctx context.Context, caller, callee, method string,
+	req, rsp interface{}, cost time.Duration, err error) {
+	if err == nil && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	fields := []log.Field{
+		{Key: "caller", Value: caller},
+		{Key: "callee", Value: callee},
+		{Key: "method", Value: method},
+		{Key: "cost_ms", Value: float64(cost.Microseconds()) / 1000},
+		{Key: "code", Value: int(errs.Code(err))},
+		{Key: "req_size", Value: payloadSize(req)},
+		{Key: "rsp_size", Value: payloadSize(rsp)},
+	}
+	if l.logPayload {
+		fields = append(fields, log.Field{Key: "req", Value: l.payload(req)})
+		if err == nil {
+			fields = append(fields, log.Field{Key: "rsp", Value: l.payload(rsp)})
+		}
+	}
+	logger := log.WithContext(ctx, fields...)
+	if err != nil {
+		logger.Errorf("[ACCESS] %s failed: %s", method, errs.Msg(err))
+		return
+	}
+	logger.Info("[ACCESS] ok")
+}
+
+// LoggingFilter writes one structured access log record per RPC, see AccessLogConfig.
 func LoggingFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
 	start := time.Now()
-	fmt.Printf("[LOG] Recv Request: %+v\n", req)
 
 	rsp, err = next(ctx, req)
 
-	cost := time.Since(start)
-	if err != nil {
-		fmt.Printf("[LOG] Handle Error: %v, Cost: %v\n", err, cost)
-	} else {
-		fmt.Printf("[LOG] Send Response: %+v, Cost: %v\n", rsp, cost)
-	}
+	msg := codec.Message(ctx)
+	defaultAccessLogger.Load().(*accessLogger).log(ctx, msg.CallerServiceName(), msg.CalleeServiceName(),
+		msg.ServerRPCName(), req, rsp, time.Since(start), err)
 	return rsp, err
 }
 
+// LoggingClientFilter writes the same access log record as LoggingFilter for the called RPCs.
+func LoggingClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	start := time.Now()
+
+	err := next(ctx, req, rsp)
+
+	msg := codec.Message(ctx)
+	defaultAccessLogger.Load().(*accessLogger).log(ctx, msg.CallerServiceName(), msg.CalleeServiceName(),
+		msg.ClientRPCName(), req, rsp, time.Since(start), err)
+	return err
+}
+
+// LoggingPluginFactory configures the sampling and redaction of the logging filters.
+type LoggingPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *LoggingPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the access logger.
+func (f *LoggingPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg AccessLogConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	defaultAccessLogger.Store(newAccessLogger(cfg))
+	return nil
+}
+
 // Register filters
 func init() {
-	filter.Register("logging", LoggingFilter, nil)
+	plugin.Register("logging", &LoggingPluginFactory{})
+	filter.Register("logging", LoggingFilter, LoggingClientFilter)
 }
@@ -0,0 +1,143 @@
+package common
+
+import (
+	"context"
+	"sync/atomic"
+
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// MetadataConfig is the `plugins.filter.metadata` section of trpc_go.yaml.
+//
+//	plugins:
+//	  filter:
+//	    metadata:
+//	      inject:
+//	        x-client-version: "1.2.0"
+//	      propagate:
+//	        - x-request-id
+type MetadataConfig struct {
+	// Inject is added to the metadata of the received requests and of the calls,
+	// keys already set are kept.
+	Inject map[string]string `yaml:"inject"`
+	// Propagate lists the keys received by the server which are forwarded to the downstream
+	// calls made with the same ctx. trpc forwards every key by default, when Propagate
+	// is set the others, e.g. credentials, are dropped unless the call sets them with
+	// client.WithMetaData.
+	Propagate []string `yaml:"propagate"`
+}
+
+// metadataRules is the resolved MetadataConfig.
+type metadataRules struct {
+	inject    map[string]string
+	propagate map[string]bool // nil to forward every key
+}
+
+func newMetadataRules(cfg MetadataConfig) *metadataRules {
+	r := &metadataRules{inject: cfg.Inject}
+	if cfg.Propagate != nil {
+		r.propagate = make(map[string]bool, len(cfg.Propagate))
+		for _, k := range cfg.Propagate {
+			r.propagate[k] = true
+		}
+	}
+	return r
+}
+
+// injectMissing returns a copy of md with the injected keys added, or md if none is missing.
+func (r *metadataRules) injectMissing(md codec.MetaData) codec.MetaData {
+	var out codec.MetaData
+	for k, v := range r.inject {
+		if _, ok := md[k]; ok {
+			continue
+		}
+		if out == nil {
+			if out = md.Clone(); out == nil {
+				out = codec.MetaData{}
+			}
+		}
+		out[k] = []byte(v)
+	}
+	if out == nil {
+		return md
+	}
+	return out
+}
+
+var defaultMetadataRules atomic.Value // *metadataRules
+
+func init() {
+	defaultMetadataRules.Store(newMetadataRules(MetadataConfig{}))
+}
+
+// MetadataFilter adds the injected keys missing from the request metadata, before the
+// filters and the handler behind it read them.
+func MetadataFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	r := defaultMetadataRules.Load().(*metadataRules)
+	msg := codec.Message(ctx)
+	msg.WithServerMetaData(r.injectMissing(msg.ServerMetaData()))
+
+	return next(ctx, req)
+}
+
+// MetadataClientFilter drops the received keys which must not be propagated, and adds
+// the injected keys missing from the call metadata.
+func MetadataClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	r := defaultMetadataRules.Load().(*metadataRules)
+	msg := codec.Message(ctx)
+	md := msg.ClientMetaData()
+
+	if r.propagate != nil {
+		// The client stub copied the metadata received by the server into the call metadata,
+		// then added the keys set with client.WithMetaData, which are kept whatever their value.
+		set := client.OptionsFromContext(ctx).MetaData
+		var kept codec.MetaData
+		for k := range msg.ServerMetaData() {
+			if r.propagate[k] {
+				continue
+			}
+			if _, ok := set[k]; ok {
+				continue
+			}
+			if _, ok := md[k]; !ok {
+				continue
+			}
+			if kept == nil {
+				kept = md.Clone()
+			}
+			delete(kept, k)
+		}
+		if kept != nil {
+			md = kept
+		}
+	}
+	msg.WithClientMetaData(r.injectMissing(md))
+
+	return next(ctx, req, rsp)
+}
+
+// MetadataPluginFactory configures the keys of the metadata filters.
+type MetadataPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *MetadataPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the keys.
+func (f *MetadataPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg MetadataConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	defaultMetadataRules.Store(newMetadataRules(cfg))
+	return nil
+}
+
+func init() {
+	plugin.Register("metadata", &MetadataPluginFactory{})
+	filter.Register("metadata", MetadataFilter, MetadataClientFilter)
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// MethodChainConfig is the `plugins.filter.method_chain` section of trpc_go.yaml.
+// Filters listed in the service config run for every method, method_chain runs the
+// filters selected for the method at its position in the service chain.
+//
+//	server:
+//	  service:
+//	    - name: trpc.helloworld.Greeter
+//	      filter:
+//	        - logging
+//	        - method_chain
+//	plugins:
+//	  filter:
+//	    method_chain:
+//	      default: [recovery, auth, ratelimit]
+//	      methods:
+//	        /trpc.helloworld.Greeter/Health:
+//	          exclude: [auth]
+//	        /trpc.helloworld.Greeter/Search:
+//	          include: [adaptive_limit]
+type MethodChainConfig struct {
+	Default []string                   `yaml:"default"` // chain of the methods not listed in Methods
+	Methods map[string]MethodChainRule `yaml:"methods"` // RPC name => rule
+}
+
+// MethodChainRule derives the chain of one method from the default chain.
+type MethodChainRule struct {
+	Filters []string `yaml:"filters"` // replaces the default chain, in this order
+	Include []string `yaml:"include"` // appended to the chain, if not already in it
+	Exclude []string `yaml:"exclude"` // removed from the chain
+}
+
+// names returns the filter names of the rule applied to base.
+func (r MethodChainRule) names(base []string) []string {
+	if r.Filters != nil {
+		base = r.Filters
+	}
+	excluded := make(map[string]bool, len(r.Exclude))
+	for _, name := range r.Exclude {
+		excluded[name] = true
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range append(append([]string(nil), base...), r.Include...) {
+		if excluded[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// methodChains holds the resolved chains of a MethodChainConfig.
+type methodChains struct {
+	def     filter.ServerChain
+	methods map[string]filter.ServerChain
+}
+
+// newMethodChains resolves the filter names, it fails if a filter is not registered.
+func newMethodChains(cfg *MethodChainConfig) (*methodChains, error) {
+	def, err := serverChain(cfg.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+	c := &methodChains{def: def, methods: make(map[string]filter.ServerChain, len(cfg.Methods))}
+	for rpc, rule := range cfg.Methods {
+		chain, err := serverChain(rule.names(cfg.Default))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rpc, err)
+		}
+		c.methods[rpc] = chain
+	}
+	return c, nil
+}
+
+func serverChain(names []string) (filter.ServerChain, error) {
+	chain := make(filter.ServerChain, 0, len(names))
+	for _, name := range names {
+		if name == "method_chain" {
+			return nil, fmt.Errorf("method_chain cannot be nested")
+		}
+		f := filter.GetServer(name)
+		if f == nil {
+			return nil, fmt.Errorf("filter %s is not registered", name)
+		}
+		chain = append(chain, f)
+	}
+	return chain, nil
+}
+
+var defaultMethodChains atomic.Value // *methodChains
+
+func init() {
+	defaultMethodChains.Store(&methodChains{})
+}
+
+// MethodChainFilter runs the filters configured for the served method, see MethodChainConfig.
+func MethodChainFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	c := defaultMethodChains.Load().(*methodChains)
+	chain, ok := c.methods[codec.Message(ctx).ServerRPCName()]
+	if !ok {
+		chain = c.def
+	}
+	return chain.Filter(ctx, req, next)
+}
+
+// MethodChainPluginFactory resolves and reloads the chains of MethodChainFilter.
+type MethodChainPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *MethodChainPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup resolves the chains and reloads them when trpc_go.yaml changes. An invalid
+// reload keeps the previous chains.
+func (f *MethodChainPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	apply := func(dec plugin.Decoder) error {
+		var cfg MethodChainConfig
+		if err := dec.Decode(&cfg); err != nil {
+			return err
+		}
+		c, err := newMethodChains(&cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defaultMethodChains.Store(c)
+		return nil
+	}
+	if err := apply(dec); err != nil {
+		return err
+	}
+	return watchPluginConfig(name, apply)
+}
+
+func init() {
+	plugin.Register("method_chain", &MethodChainPluginFactory{})
+	filter.Register("method_chain", MethodChainFilter, nil)
+}
@@ -2,29 +2,82 @@ package common
 
 import (
 	"context"
-	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
 	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/metrics"
 )
 
-// MetricsFilter collects execution time metrics.
+// Names of the RED records, each record carries the requests, errors and latency_ms metrics.
+const (
+	ServerMetricsName = "rpc_server"
+	ClientMetricsName = "rpc_client"
+)
+
+// latencyBounds are the buckets of the latency_ms histograms.
+var latencyBounds = metrics.NewValueBounds(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000)
+
+// registerLatencyHistograms runs at the first report rather than in init,
+// so that sinks registered by plugins also get the bucket configuration.
+var registerLatencyHistograms sync.Once
+
+// reportRED reports one RPC to every registered metrics sink.
+func reportRED(name, service, method, caller string, cost time.Duration, err error) {
+	registerLatencyHistograms.Do(func() {
+		for _, n := range []string{ServerMetricsName, ClientMetricsName} {
+			metrics.RegisterHistogram(n+".latency_ms", metrics.HistogramOption{BucketBounds: latencyBounds})
+		}
+	})
+
+	var failed float64
+	if err != nil {
+		failed = 1
+	}
+	_ = metrics.ReportMultiDimensionMetricsX(name,
+		[]*metrics.Dimension{
+			{Name: "service", Value: service},
+			{Name: "method", Value: method},
+			{Name: "caller", Value: caller},
+			{Name: "code", Value: strconv.Itoa(int(errs.Code(err)))},
+		},
+		[]*metrics.Metrics{
+			metrics.NewMetrics("requests", 1, metrics.PolicySUM),
+			metrics.NewMetrics("errors", failed, metrics.PolicySUM),
+			metrics.NewMetrics("latency_ms", float64(cost.Microseconds())/1000, metrics.PolicyHistogram),
+		},
+	)
+}
+
+// MetricsFilter records request count, error count and latency of the served RPCs,
+// labeled by service, method, caller and return code.
 func MetricsFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
 	start := time.Now()
 
 	rsp, err = next(ctx, req)
 
-	cost := time.Since(start)
 	msg := codec.Message(ctx)
-	rpcName := msg.ServerRPCName()
+	reportRED(ServerMetricsName, msg.CalleeServiceName(), msg.ServerRPCName(), msg.CallerServiceName(),
+		time.Since(start), err)
+	return rsp, err
+}
+
+// MetricsClientFilter records the same metrics as MetricsFilter for the called RPCs,
+// the service label is the callee.
+func MetricsClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	start := time.Now()
 
-	// In real world, you should report to Prometheus/InfluxDB here.
-	fmt.Printf("[METRICS] RPC: %s, Cost: %dms\n", rpcName, cost.Milliseconds())
+	err := next(ctx, req, rsp)
 
-	return rsp, err
+	msg := codec.Message(ctx)
+	reportRED(ClientMetricsName, msg.CalleeServiceName(), msg.ClientRPCName(), msg.CallerServiceName(),
+		time.Since(start), err)
+	return err
 }
 
 func init() {
-	filter.Register("metrics", MetricsFilter, nil)
+	filter.Register("metrics", MetricsFilter, MetricsClientFilter)
 }
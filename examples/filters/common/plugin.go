@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	trpc "trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/config"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// pluginType is the plugin type shared by every configurable filter in this package,
+// i.e. the `plugins.filter.<name>` section of trpc_go.yaml.
+const pluginType = "filter"
+
+// fileWatchers fans out the changes of one file to every filter watching it.
+// The config package keeps only the first watch hook of a path, so a path is loaded once.
+var fileWatchers = struct {
+	mu    sync.Mutex
+	files map[string]*watchedFile
+}{
+	files: make(map[string]*watchedFile),
+}
+
+type watchedFile struct {
+	cfg       config.Config
+	callbacks []func(data []byte) error
+}
+
+func (f *watchedFile) notify(path string, data []byte) {
+	fileWatchers.mu.Lock()
+	callbacks := f.callbacks
+	fileWatchers.mu.Unlock()
+
+	for _, cb := range callbacks {
+		if err := cb(data); err != nil {
+			log.Errorf("reload %s failed: %v", path, err)
+		}
+	}
+	log.Infof("%s reloaded", path)
+}
+
+// watchFile returns the content of path and calls onChange every time the file is written.
+// Note that the file provider only reacts to in-place writes, an editor that
+// saves by renaming a temp file over the path is not noticed.
+func watchFile(path string, onChange func(data []byte) error) ([]byte, error) {
+	fileWatchers.mu.Lock()
+	defer fileWatchers.mu.Unlock()
+
+	if f, ok := fileWatchers.files[path]; ok {
+		f.callbacks = append(f.callbacks, onChange)
+		return f.cfg.Bytes(), nil
+	}
+
+	codec := "yaml"
+	if filepath.Ext(path) == ".json" {
+		codec = "json"
+	}
+	f := &watchedFile{callbacks: []func(data []byte) error{onChange}}
+	cfg, err := config.Load(path,
+		config.WithCodec(codec),
+		config.WithProvider("file"),
+		config.WithWatch(),
+		config.WithWatchHook(func(msg config.WatchMessage) {
+			if msg.Error != nil {
+				log.Errorf("reload %s failed: %v", path, msg.Error)
+				return
+			}
+			f.notify(path, msg.Value)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	f.cfg = cfg
+	fileWatchers.files[path] = f
+	return cfg.Bytes(), nil
+}
+
+// watchPluginConfig calls onChange with the `plugins.filter.<name>` section every time
+// the server config file is written, so a filter picks up new settings without restarting.
+func watchPluginConfig(name string, onChange func(dec plugin.Decoder) error) error {
+	_, err := watchFile(trpc.ServerConfigPath, func(data []byte) error {
+		var cfg struct {
+			Plugins plugin.Config `yaml:"plugins"`
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return err
+		}
+		node, ok := cfg.Plugins[pluginType][name]
+		if !ok {
+			return nil
+		}
+		if err := onChange(&node); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	thttp "trpc.group/trpc-go/trpc-go/http"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// Where the priority of a request is read from.
+const (
+	MetaPriority   = "x-priority"
+	HeaderPriority = "X-Priority"
+)
+
+// PriorityConfig is the `plugins.filter.priority` section of trpc_go.yaml. The load is
+// the ratio of the requests being handled to MaxInflight, a request is admitted while
+// the load stays at or below the threshold of its priority. It is reloaded when
+// trpc_go.yaml changes.
+//
+//	plugins:
+//	  filter:
+//	    priority:
+//	      max_inflight: 200
+//	      default: normal
+//	      thresholds:
+//	        critical: 1.0
+//	        normal: 0.8
+//	        batch: 0.5
+type PriorityConfig struct {
+	MaxInflight int64              `yaml:"max_inflight"` // default 100
+	Default     string             `yaml:"default"`      // priority of requests without or with an unknown one
+	Thresholds  map[string]float64 `yaml:"thresholds"`   // priority => max load
+}
+
+func (c *PriorityConfig) normalize() error {
+	if c.MaxInflight <= 0 {
+		c.MaxInflight = 100
+	}
+	if len(c.Thresholds) == 0 {
+		c.Thresholds = map[string]float64{"critical": 1, "normal": 0.8, "batch": 0.5}
+		if c.Default == "" {
+			c.Default = "normal"
+		}
+	}
+	if _, ok := c.Thresholds[c.Default]; !ok {
+		return fmt.Errorf("default priority %q has no threshold", c.Default)
+	}
+	return nil
+}
+
+var (
+	defaultPriorityConfig atomic.Value // *PriorityConfig
+	priorityInflight      int64
+)
+
+func init() {
+	cfg := &PriorityConfig{}
+	_ = cfg.normalize()
+	defaultPriorityConfig.Store(cfg)
+}
+
+// requestPriority reads the X-Priority header of HTTP requests, or the x-priority
+// metadata of trpc requests.
+func requestPriority(ctx context.Context) string {
+	if head := thttp.Head(ctx); head != nil && head.Request != nil {
+		return strings.ToLower(head.Request.Header.Get(HeaderPriority))
+	}
+	return strings.ToLower(string(codec.Message(ctx).ServerMetaData()[MetaPriority]))
+}
+
+// PriorityFilter sheds the lower priority requests first as the load rises. Rejected
+// requests are counted by the priority.<priority>.rejected counter.
+func PriorityFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	cfg := defaultPriorityConfig.Load().(*PriorityConfig)
+	priority := requestPriority(ctx)
+	threshold, ok := cfg.Thresholds[priority]
+	if !ok {
+		priority, threshold = cfg.Default, cfg.Thresholds[cfg.Default]
+	}
+
+	inflight := atomic.AddInt64(&priorityInflight, 1)
+	defer atomic.AddInt64(&priorityInflight, -1)
+	if load := float64(inflight) / float64(cfg.MaxInflight); load > threshold {
+		metrics.Counter("priority." + priority + ".rejected").Incr()
+		log.DebugContextf(ctx, "[PRIORITY] Rejected Priority: %s, Load: %.2f, Threshold: %.2f",
+			priority, load, threshold)
+		return nil, errs.NewFrameError(errs.RetServerOverload,
+			fmt.Sprintf("priority: %s requests are shed at load %.2f", priority, load))
+	}
+
+	return next(ctx, req)
+}
+
+// PriorityPluginFactory configures the thresholds of PriorityFilter.
+type PriorityPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *PriorityPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup applies the config and reloads it when trpc_go.yaml changes. An invalid
+// reload keeps the previous config.
+func (f *PriorityPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	apply := func(dec plugin.Decoder) error {
+		var cfg PriorityConfig
+		if err := dec.Decode(&cfg); err != nil {
+			return err
+		}
+		if err := cfg.normalize(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defaultPriorityConfig.Store(&cfg)
+		return nil
+	}
+	if err := apply(dec); err != nil {
+		return err
+	}
+	return watchPluginConfig(name, apply)
+}
+
+func init() {
+	plugin.Register("priority", &PriorityPluginFactory{})
+	filter.Register("priority", PriorityFilter, nil)
+}
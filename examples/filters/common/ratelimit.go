@@ -1,32 +1,322 @@
 package common
 
 import (
+	"container/list"
 	"context"
-	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
 	"trpc.group/trpc-go/trpc-go/filter"
+	thttp "trpc.group/trpc-go/trpc-go/http"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
 )
 
-var (
-	maxRequests = int64(100)
-	curRequests = int64(0)
+// Rate limit algorithms.
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmSlidingWindow = "sliding_window"
 )
 
-// RateLimitFilter limits the concurrency of requests.
+// Rate limit scopes, i.e. which request attributes share one limiter.
+const (
+	ScopeService = "service"
+	ScopeMethod  = "method"
+	ScopeCaller  = "caller"
+)
+
+// RateLimitConfig is the `plugins.filter.ratelimit` section of trpc_go.yaml:
+//
+//	plugins:
+//	  filter:
+//	    ratelimit:
+//	      rules:
+//	        - service: trpc.helloworld.Greeter
+//	          method: /trpc.helloworld.Greeter/Hello
+//	          scope: caller
+//	          algorithm: sliding_window
+//	          rate: 100
+//	          window: 1s
+//	          max_keys: 10000
+type RateLimitConfig struct {
+	Rules []RateLimitRule `yaml:"rules"`
+}
+
+// RateLimitRule limits the requests matched by Service, Method and Caller.
+// An empty match field matches everything. The first matched rule wins.
+type RateLimitRule struct {
+	Service   string        `yaml:"service"`   // callee service, e.g. trpc.helloworld.Greeter
+	Method    string        `yaml:"method"`    // msg.ServerRPCName(), e.g. /trpc.helloworld.Greeter/Hello
+	Caller    string        `yaml:"caller"`    // msg.CallerServiceName()
+	Scope     string        `yaml:"scope"`     // service, method (default) or caller
+	Algorithm string        `yaml:"algorithm"` // token_bucket (default) or sliding_window
+	Rate      int           `yaml:"rate"`      // tokens per second, or requests per window
+	Burst     int           `yaml:"burst"`     // bucket size of token_bucket, defaults to Rate
+	Window    time.Duration `yaml:"window"`    // window size of sliding_window, defaults to 1s
+	MaxKeys   int           `yaml:"max_keys"`  // limiters kept, e.g. one per caller, defaults to 10000
+}
+
+func (r *RateLimitRule) match(service, method, caller string) bool {
+	return (r.Service == "" || r.Service == service) &&
+		(r.Method == "" || r.Method == method) &&
+		(r.Caller == "" || r.Caller == caller)
+}
+
+func (r *RateLimitRule) key(service, method, caller string) string {
+	switch r.Scope {
+	case ScopeService:
+		return service
+	case ScopeCaller:
+		return service + "|" + method + "|" + caller
+	default:
+		return service + "|" + method
+	}
+}
+
+// normalize validates the rule and fills in the defaults.
+func (r *RateLimitRule) normalize() error {
+	if r.Rate <= 0 {
+		return fmt.Errorf("rate must be positive, got %d", r.Rate)
+	}
+	switch r.Scope {
+	case "":
+		r.Scope = ScopeMethod
+	case ScopeService, ScopeMethod, ScopeCaller:
+	default:
+		return fmt.Errorf("unknown scope %q", r.Scope)
+	}
+	switch r.Algorithm {
+	case "":
+		r.Algorithm = AlgorithmTokenBucket
+	case AlgorithmTokenBucket, AlgorithmSlidingWindow:
+	default:
+		return fmt.Errorf("unknown algorithm %q", r.Algorithm)
+	}
+	if r.Burst <= 0 {
+		r.Burst = r.Rate
+	}
+	if r.Window <= 0 {
+		r.Window = time.Second
+	}
+	if r.MaxKeys <= 0 {
+		r.MaxKeys = 10000
+	}
+	return nil
+}
+
+func (r *RateLimitRule) newLimiter(now time.Time) limiter {
+	if r.Algorithm == AlgorithmSlidingWindow {
+		return newSlidingWindow(r.Rate, r.Window, now)
+	}
+	return newTokenBucket(r.Rate, r.Burst, now)
+}
+
+// limiter decides whether a request arriving at now is allowed.
+type limiter interface {
+	Allow(now time.Time) bool
+}
+
+// tokenBucket refills rate tokens per second up to burst, each request takes one token.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// slidingWindow weights the previous fixed window by how much of it still overlaps
+// the sliding window, which smooths the burst at window edges with O(1) memory.
+type slidingWindow struct {
+	mu     sync.Mutex
+	limit  float64
+	window time.Duration
+	start  time.Time // start of the current fixed window
+	cur    float64
+	prev   float64
+}
+
+func newSlidingWindow(limit int, window time.Duration, now time.Time) *slidingWindow {
+	return &slidingWindow{limit: float64(limit), window: window, start: now}
+}
+
+func (w *slidingWindow) Allow(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if elapsed := now.Sub(w.start); elapsed >= w.window {
+		if elapsed >= 2*w.window {
+			w.prev = 0
+		} else {
+			w.prev = w.cur
+		}
+		w.cur = 0
+		w.start = w.start.Add(elapsed.Truncate(w.window))
+	}
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.window)
+	if w.prev*overlap+w.cur >= w.limit {
+		return false
+	}
+	w.cur++
+	return true
+}
+
+// rateLimitRule is a validated rule with the limiters created for it.
+//
+// The callers are chosen by the clients, the limiters of scope caller are bounded by MaxKeys:
+// beyond it the least recently used is dropped. A limiter idle for a window, or for the refill
+// of its bucket, is the same as a new one, so only a flood of callers resets active limiters.
+type rateLimitRule struct {
+	RateLimitRule
+
+	mu       sync.Mutex
+	limiters map[string]*list.Element // key => element of lru
+	lru      *list.List               // *keyedLimiter, the most recently used in front
+}
+
+// keyedLimiter is the limiter of a key of a rule.
+type keyedLimiter struct {
+	key     string
+	limiter limiter
+}
+
+func newRateLimitRule(r RateLimitRule) *rateLimitRule {
+	return &rateLimitRule{RateLimitRule: r, limiters: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (r *rateLimitRule) allow(key string, now time.Time) bool {
+	r.mu.Lock()
+	e, ok := r.limiters[key]
+	if ok {
+		r.lru.MoveToFront(e)
+	} else {
+		if r.lru.Len() >= r.MaxKeys {
+			oldest := r.lru.Back()
+			r.lru.Remove(oldest)
+			delete(r.limiters, oldest.Value.(*keyedLimiter).key)
+		}
+		e = r.lru.PushFront(&keyedLimiter{key: key, limiter: r.newLimiter(now)})
+		r.limiters[key] = e
+	}
+	l := e.Value.(*keyedLimiter).limiter
+	r.mu.Unlock()
+
+	return l.Allow(now)
+}
+
+// RateLimiter holds the active rules. Rules are swapped as a whole on reload, the rules
+// left unchanged keep their limiters, so that reloading trpc_go.yaml does not reset them.
+type RateLimiter struct {
+	rules atomic.Value // []*rateLimitRule
+}
+
+// NewRateLimiter creates a RateLimiter which allows everything until rules are set.
+func NewRateLimiter() *RateLimiter {
+	l := &RateLimiter{}
+	l.rules.Store([]*rateLimitRule(nil))
+	return l
+}
+
+// SetConfig validates cfg and replaces the active rules.
+func (l *RateLimiter) SetConfig(cfg *RateLimitConfig) error {
+	unchanged := make(map[RateLimitRule][]*rateLimitRule)
+	for _, r := range l.rules.Load().([]*rateLimitRule) {
+		unchanged[r.RateLimitRule] = append(unchanged[r.RateLimitRule], r)
+	}
+	rules := make([]*rateLimitRule, 0, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		if err := r.normalize(); err != nil {
+			return fmt.Errorf("ratelimit rule %d: %w", i, err)
+		}
+		if old := unchanged[r]; len(old) > 0 {
+			rules, unchanged[r] = append(rules, old[0]), old[1:]
+			continue
+		}
+		rules = append(rules, newRateLimitRule(r))
+	}
+	l.rules.Store(rules)
+	return nil
+}
+
+// Allow reports whether the request is allowed, it also returns the matched rule.
+func (l *RateLimiter) Allow(service, method, caller string) (bool, *RateLimitRule) {
+	for _, r := range l.rules.Load().([]*rateLimitRule) {
+		if !r.match(service, method, caller) {
+			continue
+		}
+		return r.allow(r.key(service, method, caller), time.Now()), &r.RateLimitRule
+	}
+	return true, nil
+}
+
+var defaultRateLimiter = NewRateLimiter()
+
+// RateLimitFilter rejects requests exceeding the rules configured for the ratelimit plugin.
 func RateLimitFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
-	current := atomic.AddInt64(&curRequests, 1)
-	defer atomic.AddInt64(&curRequests, -1)
+	msg := codec.Message(ctx)
+	service, method, caller := msg.CalleeServiceName(), msg.ServerRPCName(), msg.CallerServiceName()
 
-	if current > maxRequests {
-		fmt.Printf("[RATELIMIT] Request Rejected. Current: %d\n", current)
-		return nil, errors.New("server overloaded")
+	if ok, rule := defaultRateLimiter.Allow(service, method, caller); !ok {
+		log.DebugContextf(ctx, "[RATELIMIT] Request Rejected. Method: %s, Caller: %s", method, caller)
+		return nil, errs.NewFrameError(errs.RetServerThrottled,
+			fmt.Sprintf("ratelimit: %s exceeds %d requests (%s)", method, rule.Rate, rule.Algorithm))
 	}
 
 	return next(ctx, req)
 }
 
+// RateLimitPluginFactory loads the rate limit rules and reloads them when trpc_go.yaml changes.
+type RateLimitPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *RateLimitPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup applies the initial rules and starts watching the config file.
+func (f *RateLimitPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	apply := func(dec plugin.Decoder) error {
+		var cfg RateLimitConfig
+		if err := dec.Decode(&cfg); err != nil {
+			return err
+		}
+		return defaultRateLimiter.SetConfig(&cfg)
+	}
+	if err := apply(dec); err != nil {
+		return err
+	}
+	return watchPluginConfig(name, apply)
+}
+
 func init() {
+	thttp.RegisterStatus(errs.RetServerThrottled, http.StatusTooManyRequests)
+	plugin.Register("ratelimit", &RateLimitPluginFactory{})
 	filter.Register("ratelimit", RateLimitFilter, nil)
 }
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// MetaReplay marks the requests sent by examples/filters/replay, they are not recorded again.
+const MetaReplay = "x-traffic-replay"
+
+// RecordConfig is the `plugins.filter.traffic_record` section of trpc_go.yaml.
+// Records are JSON lines in <dir>/traffic.<unix nano>.jsonl, replayed by examples/filters/replay.
+// They hold the request metadata, list the keys not to keep, e.g. credentials, in skip_metadata.
+type RecordConfig struct {
+	Dir          string   `yaml:"dir"`           // default ./records
+	SampleRate   float64  `yaml:"sample_rate"`   // default 0.01
+	MaxFileSize  int64    `yaml:"max_file_size"` // bytes of a file before rotating, default 64MB
+	MaxFiles     int      `yaml:"max_files"`     // the oldest files are removed beyond it, default 10
+	SkipMetadata []string `yaml:"skip_metadata"` // metadata keys not recorded
+}
+
+// TrafficRecord is a recorded RPC. Bodies are serialized with the serialization type of the request.
+type TrafficRecord struct {
+	Time          time.Time         `json:"time"`
+	RPC           string            `json:"rpc"`
+	Caller        string            `json:"caller"`
+	Callee        string            `json:"callee"`
+	Metadata      map[string][]byte `json:"metadata,omitempty"`
+	Serialization int               `json:"serialization"`
+	Request       []byte            `json:"request"`
+	Response      []byte            `json:"response,omitempty"`
+	Code          int               `json:"code"`
+	Msg           string            `json:"msg,omitempty"`
+}
+
+// TrafficRecorder writes TrafficRecords to rotating files.
+type TrafficRecorder struct {
+	cfg  RecordConfig
+	skip map[string]bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewTrafficRecorder creates the directory of the records, zero fields of cfg use the defaults.
+func NewTrafficRecorder(cfg RecordConfig) (*TrafficRecorder, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "./records"
+	}
+	if cfg.SampleRate <= 0 {
+		cfg.SampleRate = 0.01
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = 64 << 20
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 10
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &TrafficRecorder{cfg: cfg, skip: make(map[string]bool)}
+	for _, k := range cfg.SkipMetadata {
+		r.skip[k] = true
+	}
+	return r, nil
+}
+
+// Write appends rec to the current file, rotating it when full.
+func (r *TrafficRecorder) Write(rec *TrafficRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil || r.size+int64(len(line)) > r.cfg.MaxFileSize {
+		if err := r.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+// rotateLocked opens a new file and removes the oldest ones beyond MaxFiles.
+func (r *TrafficRecorder) rotateLocked() error {
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+	path := filepath.Join(r.cfg.Dir, fmt.Sprintf("traffic.%d.jsonl", time.Now().UnixNano()))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file, r.size = f, 0
+
+	files, err := filepath.Glob(filepath.Join(r.cfg.Dir, "traffic.*.jsonl"))
+	if err != nil {
+		return err
+	}
+	// Names have the same length until 2286, so they sort by time.
+	sort.Strings(files)
+	for len(files) > r.cfg.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			log.Errorf("[RECORD] Remove %s failed: %v", files[0], err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Close closes the current file.
+func (r *TrafficRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+var defaultTrafficRecorder atomic.Value // *TrafficRecorder
+
+// TrafficRecordFilter records a sample of the requests and their responses, see RecordConfig.
+// It does nothing until the traffic_record plugin is configured.
+func TrafficRecordFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	r, _ := defaultTrafficRecorder.Load().(*TrafficRecorder)
+	msg := codec.Message(ctx)
+	if r == nil || rand.Float64() >= r.cfg.SampleRate || msg.ServerMetaData()[MetaReplay] != nil {
+		return next(ctx, req)
+	}
+
+	// Serialize the request before the handler, which may modify it.
+	body, merr := codec.Marshal(msg.SerializationType(), req)
+	start := time.Now()
+
+	rsp, err = next(ctx, req)
+
+	if merr != nil {
+		log.WarnContextf(ctx, "[RECORD] Marshal Request of %s failed: %v", msg.ServerRPCName(), merr)
+		return rsp, err
+	}
+	rec := &TrafficRecord{
+		Time:          start,
+		RPC:           msg.ServerRPCName(),
+		Caller:        msg.CallerServiceName(),
+		Callee:        msg.CalleeServiceName(),
+		Serialization: msg.SerializationType(),
+		Request:       body,
+		Code:          int(errs.Code(err)),
+		Msg:           errs.Msg(err),
+	}
+	for k, v := range msg.ServerMetaData() {
+		if r.skip[k] {
+			continue
+		}
+		if rec.Metadata == nil {
+			rec.Metadata = make(map[string][]byte)
+		}
+		rec.Metadata[k] = v
+	}
+	if err == nil {
+		if rec.Response, merr = codec.Marshal(msg.SerializationType(), rsp); merr != nil {
+			log.WarnContextf(ctx, "[RECORD] Marshal Response of %s failed: %v", msg.ServerRPCName(), merr)
+			return rsp, err
+		}
+	}
+	if werr := r.Write(rec); werr != nil {
+		log.WarnContextf(ctx, "[RECORD] Write failed: %v", werr)
+	}
+	return rsp, err
+}
+
+// TrafficRecordPluginFactory creates the recorder of TrafficRecordFilter.
+type TrafficRecordPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *TrafficRecordPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and creates the recorder.
+func (f *TrafficRecordPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg RecordConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	r, err := NewTrafficRecorder(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defaultTrafficRecorder.Store(r)
+	return nil
+}
+
+// Close closes the record file when the server stops.
+func (f *TrafficRecordPluginFactory) Close() error {
+	if r, _ := defaultTrafficRecorder.Load().(*TrafficRecorder); r != nil {
+		return r.Close()
+	}
+	return nil
+}
+
+func init() {
+	plugin.Register("traffic_record", &TrafficRecordPluginFactory{})
+	filter.Register("traffic_record", TrafficRecordFilter, nil)
+}
@@ -2,25 +2,275 @@ package common
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"runtime"
 	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"trpc.group/trpc-go/trpc-go/admin"
+	"trpc.group/trpc-go/trpc-go/codec"
 	"trpc.group/trpc-go/trpc-go/errs"
 	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/plugin"
 )
 
+// RecoveryConfig is the `plugins.filter.recovery` section of trpc_go.yaml.
+type RecoveryConfig struct {
+	MaxPanics    int           `yaml:"max_panics"`    // distinct panics kept for /cmds/panics, default 32
+	DumpInterval time.Duration `yaml:"dump_interval"` // min interval between two stack dumps of one panic, default 1m
+}
+
+// PanicRecord is a distinct panic, identified by the fingerprint of its stack.
+type PanicRecord struct {
+	Fingerprint string    `json:"fingerprint"`
+	RPC         string    `json:"rpc"`
+	Value       string    `json:"value"`
+	Count       int64     `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Stack       string    `json:"stack"`
+
+	lastDump time.Time
+}
+
+// PanicRecorder keeps the last distinct panics and throttles their stack dumps.
+type PanicRecorder struct {
+	mu           sync.Mutex
+	max          int
+	dumpInterval time.Duration
+	records      map[string]*PanicRecord
+}
+
+// NewPanicRecorder creates a PanicRecorder from cfg, zero fields use the defaults.
+func NewPanicRecorder(cfg RecoveryConfig) *PanicRecorder {
+	if cfg.MaxPanics <= 0 {
+		cfg.MaxPanics = 32
+	}
+	if cfg.DumpInterval <= 0 {
+		cfg.DumpInterval = time.Minute
+	}
+	return &PanicRecorder{
+		max:          cfg.MaxPanics,
+		dumpInterval: cfg.DumpInterval,
+		records:      make(map[string]*PanicRecord),
+	}
+}
+
+// panicFrames returns the frames of the panicking goroutine, starting at the
+// function which panicked. It must be called in the deferred recover function.
+func panicFrames() []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	frames := runtime.CallersFrames(pcs)
+
+	var (
+		result   []runtime.Frame
+		panicked bool
+	)
+	for {
+		f, more := frames.Next()
+		if panicked {
+			result = append(result, f)
+		} else if f.Function == "runtime.gopanic" {
+			panicked = true
+		}
+		if !more {
+			break
+		}
+	}
+	return result
+}
+
+// capturedPanic is a panic recovered in another goroutine, e.g. the one of a call shared by
+// ResponseCache, with the frames and stack of its site. Raising the bare value again in the
+// calling goroutine would record the frames of the new panic instead.
+type capturedPanic struct {
+	value  interface{}
+	frames []runtime.Frame
+	stack  string
+}
+
+// capturePanic wraps v with the stack of the panicking goroutine. It must be called in the
+// deferred recover function, a panic already captured is returned as is.
+func capturePanic(v interface{}) *capturedPanic {
+	if p, ok := v.(*capturedPanic); ok {
+		return p
+	}
+	return &capturedPanic{value: v, frames: panicFrames(), stack: string(debug.Stack())}
+}
+
+// String shows the original stack when the panic is not recovered and crashes the process.
+func (p *capturedPanic) String() string {
+	return fmt.Sprintf("%v\n\noriginal stack:\n%s", p.value, p.stack)
+}
+
+// fingerprint hashes function names and lines, without the goroutine id, arguments
+// and addresses of debug.Stack, so the same panic site always gets the same value.
+func fingerprint(frames []runtime.Frame) string {
+	h := sha1.New()
+	for _, f := range frames {
+		// Runtime frames, e.g. of a nil map write, differ between Go versions.
+		if strings.HasPrefix(f.Function, "runtime.") {
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d\n", f.Function, f.Line)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Record stores the panic and logs stack, at most once per dump interval for
+// each fingerprint. It returns the fingerprint.
+func (r *PanicRecorder) Record(ctx context.Context, rpc string, v interface{}, frames []runtime.Frame,
+	stack string) string {
+	fp := fingerprint(frames)
+	now := time.Now()
+
+	r.mu.Lock()
+	rec, ok := r.records[fp]
+	if !ok {
+		// LastSeen is set before evicting, or the new record would be the oldest.
+		rec = &PanicRecord{Fingerprint: fp, RPC: rpc, FirstSeen: now, LastSeen: now, Stack: stack}
+		r.records[fp] = rec
+		r.evictLocked()
+	}
+	rec.Value = fmt.Sprint(v)
+	rec.Count++
+	rec.LastSeen = now
+	dump := now.Sub(rec.lastDump) >= r.dumpInterval
+	if dump {
+		rec.lastDump = now
+	}
+	count, stack := rec.Count, rec.Stack
+	r.mu.Unlock()
+
+	metrics.Counter("recovery.panic." + rpc).Incr()
+	if dump {
+		log.ErrorContextf(ctx, "[RECOVERY] Captured Panic: %v, RPC: %s, Fingerprint: %s, Count: %d\nStack: %s",
+			v, rpc, fp, count, stack)
+	} else {
+		log.ErrorContextf(ctx, "[RECOVERY] Captured Panic: %v, RPC: %s, Fingerprint: %s, Count: %d",
+			v, rpc, fp, count)
+	}
+	return fp
+}
+
+// evictLocked drops the least recently seen records beyond max.
+func (r *PanicRecorder) evictLocked() {
+	for len(r.records) > r.max {
+		var oldest *PanicRecord
+		for _, rec := range r.records {
+			if oldest == nil || rec.LastSeen.Before(oldest.LastSeen) {
+				oldest = rec
+			}
+		}
+		delete(r.records, oldest.Fingerprint)
+	}
+}
+
+// Records returns the kept panics, the most recent first.
+func (r *PanicRecorder) Records() []PanicRecord {
+	r.mu.Lock()
+	records := make([]PanicRecord, 0, len(r.records))
+	for _, rec := range r.records {
+		records = append(records, *rec)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastSeen.After(records[j].LastSeen)
+	})
+	return records
+}
+
+var defaultPanicRecorder atomic.Value // *PanicRecorder
+
+func init() {
+	defaultPanicRecorder.Store(NewPanicRecorder(RecoveryConfig{}))
+}
+
+// recordPanic records a recovered panic and returns the error message for it.
+// It must be called in the deferred recover function.
+func recordPanic(ctx context.Context, rpc string, v interface{}) string {
+	p := capturePanic(v)
+	fp := defaultPanicRecorder.Load().(*PanicRecorder).Record(ctx, rpc, p.value, p.frames, p.stack)
+	return fmt.Sprintf("panic: %v, fingerprint: %s", p.value, fp)
+}
+
 // RecoveryFilter catches panic and converts it to error.
 func RecoveryFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = errs.New(errs.RetServerSystemErr, fmt.Sprintf("panic: %v", r))
-			fmt.Printf("[RECOVERY] Captured Panic: %v\nStack: %s\n", r, debug.Stack())
+			err = errs.New(errs.RetServerSystemErr, recordPanic(ctx, codec.Message(ctx).ServerRPCName(), r))
 		}
 	}()
 
 	return next(ctx, req)
 }
 
+// RecoveryClientFilter catches panic raised by the client filters behind it.
+func RecoveryClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errs.New(errs.RetUnknown, recordPanic(ctx, codec.Message(ctx).ClientRPCName(), r))
+		}
+	}()
+
+	return next(ctx, req, rsp)
+}
+
+// RecoveryHandler protects handlers of http_no_protocol services, e.g. a Gin engine
+// registered by RegisterNoProtocolServiceMux, the same way RecoveryFilter does.
+func RecoveryHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				http.Error(w, recordPanic(r.Context(), r.Method+" "+r.URL.Path, v), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePanics shows the last distinct panics by "http://ip:port/cmds/panics".
+func handlePanics(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"panics": defaultPanicRecorder.Load().(*PanicRecorder).Records(),
+	})
+}
+
+// RecoveryPluginFactory configures the panic recorder of the recovery filters.
+type RecoveryPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *RecoveryPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the panic recorder.
+func (f *RecoveryPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg RecoveryConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	defaultPanicRecorder.Store(NewPanicRecorder(cfg))
+	return nil
+}
+
 func init() {
-	filter.Register("recovery", RecoveryFilter, nil)
+	plugin.Register("recovery", &RecoveryPluginFactory{})
+	filter.Register("recovery", RecoveryFilter, RecoveryClientFilter)
+	admin.HandleFunc("/cmds/panics", handlePanics)
 }
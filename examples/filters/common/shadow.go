@@ -0,0 +1,182 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// ShadowConfig is the `plugins.filter.shadow` section of trpc_go.yaml.
+//
+//	plugins:
+//	  filter:
+//	    shadow:
+//	      services:
+//	        trpc.helloworld.Greeter:
+//	          target: ip://127.0.0.1:8001
+//	          rate: 0.1
+//	          timeout: 500ms
+//	          max_concurrent: 10
+type ShadowConfig struct {
+	Services map[string]ShadowTarget `yaml:"services"` // callee service name => shadow
+}
+
+// ShadowTarget is where the calls of one callee service are mirrored to.
+type ShadowTarget struct {
+	Target        string        `yaml:"target"`         // e.g. ip://127.0.0.1:8001
+	Rate          float64       `yaml:"rate"`           // ratio of the calls mirrored
+	Timeout       time.Duration `yaml:"timeout"`        // default 500ms
+	MaxConcurrent int           `yaml:"max_concurrent"` // shadow calls in flight, more are dropped, default 10
+}
+
+// shadow mirrors calls to one ShadowTarget.
+type shadow struct {
+	cfg   ShadowTarget
+	slots chan struct{}
+}
+
+var shadows atomic.Value // map[string]*shadow, callee service name => shadow
+
+func init() {
+	shadows.Store(map[string]*shadow{})
+}
+
+// ShadowClientFilter sends a copy of a sample of the calls to the shadow target after
+// the primary call, and compares both responses. The shadow call runs in the background
+// without the client filters, its result is only reported by the
+// shadow.<rpc>.{match,mismatch,dropped,panic} counters. Calls whose
+// response is not a pointer are not mirrored.
+func ShadowClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	msg := codec.Message(ctx)
+	s, ok := shadows.Load().(map[string]*shadow)[msg.CalleeServiceName()]
+	if !ok || rand.Float64() >= s.cfg.Rate {
+		return next(ctx, req, rsp)
+	}
+	// The shadow response is allocated from the type rsp points to.
+	if t := reflect.TypeOf(rsp); t == nil || t.Kind() != reflect.Ptr {
+		return next(ctx, req, rsp)
+	}
+
+	// Copy the request first, the caller may reuse it once the primary call returns.
+	shadowReq := req
+	if m, ok := req.(proto.Message); ok {
+		shadowReq = proto.Clone(m)
+	}
+
+	err := next(ctx, req, rsp)
+
+	primaryRsp := rsp
+	if m, ok := rsp.(proto.Message); ok && err == nil {
+		primaryRsp = proto.Clone(m)
+	}
+	rpc := msg.ClientRPCName()
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		metrics.Counter("shadow." + rpc + ".dropped").Incr()
+		return err
+	}
+	// The shadow call must outlive ctx, which is canceled when the primary call returns.
+	sctx, smsg := codec.WithCloneContextAndMessage(ctx)
+	smsg.WithClientMetaData(msg.ClientMetaData().Clone())
+	go func() {
+		defer func() {
+			// Nothing above would recover it, a panic here must not crash the process.
+			if v := recover(); v != nil {
+				metrics.Counter("shadow." + rpc + ".panic").Incr()
+				log.ErrorContextf(sctx, "[SHADOW] Panic RPC: %s, Target: %s, %s",
+					rpc, s.cfg.Target, recordPanic(sctx, rpc, v))
+			}
+			codec.PutBackMessage(smsg)
+			<-s.slots
+		}()
+		s.call(sctx, rpc, shadowReq, primaryRsp, err)
+	}()
+	return err
+}
+
+// call invokes the shadow target and reports whether its result matches the primary one.
+func (s *shadow) call(ctx context.Context, rpc string, req, primaryRsp interface{}, primaryErr error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	rsp := reflect.New(reflect.TypeOf(primaryRsp).Elem()).Interface()
+	err := client.DefaultClient.Invoke(ctx, req, rsp, client.WithTarget(s.cfg.Target), client.WithDisableFilter())
+
+	if mismatch := shadowMismatch(primaryRsp, primaryErr, rsp, err); mismatch != "" {
+		metrics.Counter("shadow." + rpc + ".mismatch").Incr()
+		log.DebugContextf(ctx, "[SHADOW] Mismatch RPC: %s, Target: %s, %s", rpc, s.cfg.Target, mismatch)
+		return
+	}
+	metrics.Counter("shadow." + rpc + ".match").Incr()
+}
+
+// shadowMismatch describes the difference between the primary and the shadow results, "" if none.
+func shadowMismatch(primaryRsp interface{}, primaryErr error, shadowRsp interface{}, shadowErr error) string {
+	if pc, sc := errs.Code(primaryErr), errs.Code(shadowErr); pc != sc {
+		return fmt.Sprintf("code: %d => %d (%s)", pc, sc, errs.Msg(shadowErr))
+	}
+	if primaryErr != nil {
+		return ""
+	}
+	pm, ok1 := primaryRsp.(proto.Message)
+	sm, ok2 := shadowRsp.(proto.Message)
+	if ok1 && ok2 {
+		if !proto.Equal(pm, sm) {
+			return fmt.Sprintf("response: %v => %v", pm, sm)
+		}
+		return ""
+	}
+	if !reflect.DeepEqual(primaryRsp, shadowRsp) {
+		return fmt.Sprintf("response: %v => %v", primaryRsp, shadowRsp)
+	}
+	return ""
+}
+
+// ShadowPluginFactory configures the shadow targets of ShadowClientFilter.
+type ShadowPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *ShadowPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the shadow targets.
+func (f *ShadowPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg ShadowConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	m := make(map[string]*shadow, len(cfg.Services))
+	for service, t := range cfg.Services {
+		if t.Target == "" {
+			return fmt.Errorf("%s: %s: target is required", name, service)
+		}
+		if t.Timeout <= 0 {
+			t.Timeout = 500 * time.Millisecond
+		}
+		if t.MaxConcurrent <= 0 {
+			t.MaxConcurrent = 10
+		}
+		m[service] = &shadow{cfg: t, slots: make(chan struct{}, t.MaxConcurrent)}
+	}
+	shadows.Store(m)
+	return nil
+}
+
+func init() {
+	plugin.Register("shadow", &ShadowPluginFactory{})
+	filter.Register("shadow", nil, ShadowClientFilter)
+}
@@ -0,0 +1,226 @@
+package common
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// Metadata keys carrying the request signature.
+const (
+	MetaSignKeyID     = "x-sign-key-id"
+	MetaSignTimestamp = "x-sign-timestamp"
+	MetaSignNonce     = "x-sign-nonce"
+	MetaSignature     = "x-signature"
+)
+
+// SigningConfig is the `plugins.filter.hmac_sign` section of trpc_go.yaml.
+// The same section serves both sides: the client signs with KeyID, the server
+// accepts any key listed in Keys.
+//
+//	plugins:
+//	  filter:
+//	    hmac_sign:
+//	      key_id: greeter-client
+//	      keys:
+//	        greeter-client: a-long-random-secret
+//	      skew: 5m
+//	      nonce_cache_size: 100000
+type SigningConfig struct {
+	KeyID string            `yaml:"key_id"` // key used by the client filter
+	Keys  map[string]string `yaml:"keys"`   // key id => shared secret
+	Skew  time.Duration     `yaml:"skew"`   // max clock difference accepted by the server, default 5m
+	// NonceCacheSize bounds the remembered nonces. It should exceed the peak QPS times
+	// 2*Skew, otherwise the oldest nonces are evicted while they could still be replayed.
+	NonceCacheSize int `yaml:"nonce_cache_size"` // default 100000
+}
+
+// signer holds the active SigningConfig and the nonces seen by the server.
+type signer struct {
+	cfg    SigningConfig
+	nonces *nonceCache
+}
+
+var defaultSigner atomic.Value // *signer
+
+func init() {
+	defaultSigner.Store(newSigner(SigningConfig{}))
+}
+
+func newSigner(cfg SigningConfig) *signer {
+	if cfg.Skew <= 0 {
+		cfg.Skew = 5 * time.Minute
+	}
+	if cfg.NonceCacheSize <= 0 {
+		cfg.NonceCacheSize = 100000
+	}
+	return &signer{cfg: cfg, nonces: newNonceCache(cfg.NonceCacheSize)}
+}
+
+// sign returns the base64 HMAC-SHA256 of the canonical request.
+func sign(secret, method, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, timestamp, nonce, hex.EncodeToString(digest[:]))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedBody serializes req for its digest, the same way on both sides: proto messages
+// deterministically, map fields are encoded in random order otherwise.
+func signedBody(serializationType int, req interface{}) ([]byte, error) {
+	if m, ok := req.(proto.Message); ok {
+		return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	}
+	return codec.Marshal(serializationType, req)
+}
+
+// SigningClientFilter signs the method name, a timestamp, a random nonce and the digest
+// of the serialized request body, and sends them in the request metadata.
+func SigningClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	s := defaultSigner.Load().(*signer)
+	secret, ok := s.cfg.Keys[s.cfg.KeyID]
+	if !ok {
+		return errs.NewFrameError(errs.RetClientValidateFail, "hmac_sign: no secret for key id "+s.cfg.KeyID)
+	}
+
+	msg := codec.Message(ctx)
+	body, err := signedBody(msg.SerializationType(), req)
+	if err != nil {
+		return errs.WrapFrameError(err, errs.RetClientEncodeFail, "hmac_sign: marshal request")
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return errs.WrapFrameError(err, errs.RetClientValidateFail, "hmac_sign: generate nonce")
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceStr := hex.EncodeToString(nonce)
+
+	md := msg.ClientMetaData().Clone()
+	if md == nil {
+		md = codec.MetaData{}
+	}
+	md[MetaSignKeyID] = []byte(s.cfg.KeyID)
+	md[MetaSignTimestamp] = []byte(timestamp)
+	md[MetaSignNonce] = []byte(nonceStr)
+	md[MetaSignature] = []byte(sign(secret, msg.ClientRPCName(), timestamp, nonceStr, body))
+	msg.WithClientMetaData(md)
+
+	return next(ctx, req, rsp)
+}
+
+// SigningServerFilter verifies the signature added by SigningClientFilter. It rejects
+// requests signed outside the clock skew window and requests reusing a nonce.
+func SigningServerFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	s := defaultSigner.Load().(*signer)
+	msg := codec.Message(ctx)
+	md := msg.ServerMetaData()
+
+	keyID, timestamp, nonce := string(md[MetaSignKeyID]), string(md[MetaSignTimestamp]), string(md[MetaSignNonce])
+	secret, ok := s.cfg.Keys[keyID]
+	if !ok {
+		return nil, errs.NewFrameError(errs.RetServerAuthFail, fmt.Sprintf("hmac_sign: unknown key id %q", keyID))
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errs.NewFrameError(errs.RetServerAuthFail, "hmac_sign: bad timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > s.cfg.Skew || skew < -s.cfg.Skew {
+		return nil, errs.NewFrameError(errs.RetServerAuthFail, fmt.Sprintf("hmac_sign: timestamp skew %s", skew))
+	}
+
+	body, err := signedBody(msg.SerializationType(), req)
+	if err != nil {
+		return nil, errs.WrapFrameError(err, errs.RetServerDecodeFail, "hmac_sign: marshal request")
+	}
+	want := sign(secret, msg.ServerRPCName(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(want), md[MetaSignature]) {
+		return nil, errs.NewFrameError(errs.RetServerAuthFail, "hmac_sign: signature mismatch")
+	}
+	// Remember the nonce only after the signature is verified, so forged requests
+	// cannot fill the cache.
+	if !s.nonces.add(keyID+":"+nonce, now.Add(2*s.cfg.Skew), now) {
+		log.WarnContextf(ctx, "[SIGN] Replayed Nonce: %s, Key: %s", nonce, keyID)
+		return nil, errs.NewFrameError(errs.RetServerAuthFail, "hmac_sign: nonce reused")
+	}
+
+	return next(ctx, req)
+}
+
+// nonceCache remembers nonces until they expire. It holds at most size entries,
+// the oldest entry is evicted when it is full.
+type nonceCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	ring    []nonceSlot // insertion order, used to find the oldest entry
+	head    int
+}
+
+// nonceSlot is an insertion of a nonce, stale once the nonce is added again after it expired.
+type nonceSlot struct {
+	nonce  string
+	expire time.Time
+}
+
+func newNonceCache(size int) *nonceCache {
+	return &nonceCache{expires: make(map[string]time.Time, size), ring: make([]nonceSlot, size)}
+}
+
+// add stores nonce and returns false if it is already stored and not expired.
+func (c *nonceCache) add(nonce string, expire, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if exp, ok := c.expires[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	// A stale slot must not delete the entry of a later insertion.
+	if old := c.ring[c.head]; old.nonce != "" && c.expires[old.nonce].Equal(old.expire) {
+		delete(c.expires, old.nonce)
+	}
+	c.ring[c.head] = nonceSlot{nonce: nonce, expire: expire}
+	c.head = (c.head + 1) % len(c.ring)
+	c.expires[nonce] = expire
+	return true
+}
+
+// SigningPluginFactory loads the shared secrets of the hmac_sign filters.
+type SigningPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *SigningPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the secrets.
+func (f *SigningPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg SigningConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	if len(cfg.Keys) == 0 {
+		return fmt.Errorf("%s: keys is required", name)
+	}
+	defaultSigner.Store(newSigner(cfg))
+	return nil
+}
+
+func init() {
+	plugin.Register("hmac_sign", &SigningPluginFactory{})
+	filter.Register("hmac_sign", SigningServerFilter, SigningClientFilter)
+}
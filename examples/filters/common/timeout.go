@@ -0,0 +1,106 @@
+package common
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// TimeoutConfig is the `plugins.filter.timeout` section of trpc_go.yaml. The server
+// filter looks up the served RPC name, the client filter the called one.
+//
+//	plugins:
+//	  filter:
+//	    timeout:
+//	      default: 1s
+//	      methods:
+//	        /trpc.helloworld.Greeter/Hello: 200ms
+type TimeoutConfig struct {
+	Default time.Duration            `yaml:"default"` // 0 means no timeout besides the framework one
+	Methods map[string]time.Duration `yaml:"methods"` // RPC name => timeout
+}
+
+// timeout returns the timeout of rpc, 0 if none.
+func (c *TimeoutConfig) timeout(rpc string) time.Duration {
+	if t, ok := c.Methods[rpc]; ok {
+		return t
+	}
+	return c.Default
+}
+
+var defaultTimeoutConfig atomic.Value // *TimeoutConfig
+
+func init() {
+	defaultTimeoutConfig.Store(&TimeoutConfig{})
+}
+
+// TimeoutFilter bounds the handler with the method timeout, and fails the RPC with
+// RetServerTimeout once the deadline is exceeded. The handler runs in the calling
+// goroutine, so it must honor ctx to return early: running it in the background would
+// let it use the request and the pooled message after the framework reused them.
+func TimeoutFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (interface{}, error) {
+	rpc := codec.Message(ctx).ServerRPCName()
+	d := defaultTimeoutConfig.Load().(*TimeoutConfig).timeout(rpc)
+	if d <= 0 {
+		return next(ctx, req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	rsp, err := next(ctx, req)
+	// Not when the caller canceled the RPC, ctx.Err() is then context.Canceled.
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errs.NewFrameError(errs.RetServerTimeout, "timeout: "+rpc+" exceeded "+d.String())
+	}
+	return rsp, err
+}
+
+// TimeoutClientFilter bounds the call with the method timeout. The shorter deadline is
+// also sent to the server as the request timeout.
+func TimeoutClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	msg := codec.Message(ctx)
+	d := defaultTimeoutConfig.Load().(*TimeoutConfig).timeout(msg.ClientRPCName())
+	if d <= 0 {
+		return next(ctx, req, rsp)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	msg.WithRequestTimeout(time.Until(deadline))
+
+	err := next(ctx, req, rsp)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return errs.WrapFrameError(err, errs.RetClientTimeout, "timeout: "+msg.ClientRPCName()+" exceeded "+d.String())
+	}
+	return err
+}
+
+// TimeoutPluginFactory configures the method timeouts of the timeout filters.
+type TimeoutPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *TimeoutPluginFactory) Type() string {
+	return pluginType
+}
+
+// Setup decodes the config and replaces the timeouts.
+func (f *TimeoutPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg TimeoutConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	defaultTimeoutConfig.Store(&cfg)
+	return nil
+}
+
+func init() {
+	plugin.Register("timeout", &TimeoutPluginFactory{})
+	filter.Register("timeout", TimeoutFilter, TimeoutClientFilter)
+}
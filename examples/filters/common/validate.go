@@ -0,0 +1,169 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"trpc-go-note/examples/filters/validate"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+)
+
+// patterns caches the compiled FieldRules.pattern regular expressions.
+var patterns sync.Map // string => *regexp.Regexp
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// Validate checks the (trpc.validate.rules) options of m and its nested messages.
+// It returns one violation per field, e.g. "user.name: length must be at most 64".
+func Validate(m proto.Message) []string {
+	var violations []string
+	validateMessage(m.ProtoReflect(), "", &violations)
+	return violations
+}
+
+func validateMessage(m protoreflect.Message, prefix string, violations *[]string) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		path := prefix + string(fd.Name())
+
+		if rules, ok := proto.GetExtension(fd.Options(), validate.E_Rules).(*validate.FieldRules); ok && rules != nil {
+			if msg := checkField(m, fd, rules); msg != "" {
+				*violations = append(*violations, path+": "+msg)
+				continue
+			}
+		}
+		if !m.Has(fd) {
+			continue
+		}
+		switch v := m.Get(fd); {
+		case fd.IsList() && fd.Message() != nil:
+			for j := 0; j < v.List().Len(); j++ {
+				validateMessage(v.List().Get(j).Message(), fmt.Sprintf("%s[%d].", path, j), violations)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				validateMessage(mv.Message(), fmt.Sprintf("%s[%v].", path, k.Interface()), violations)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			validateMessage(v.Message(), path+".", violations)
+		}
+	}
+}
+
+// checkField returns the first violated rule of the field, "" if none.
+func checkField(m protoreflect.Message, fd protoreflect.FieldDescriptor, rules *validate.FieldRules) string {
+	if !m.Has(fd) {
+		if rules.GetRequired() {
+			return "is required"
+		}
+		return ""
+	}
+	v := m.Get(fd)
+
+	if rules.MinLen != nil || rules.MaxLen != nil {
+		var n int
+		switch {
+		case fd.IsList():
+			n = v.List().Len()
+		case fd.IsMap():
+			n = v.Map().Len()
+		case fd.Kind() == protoreflect.StringKind:
+			n = utf8.RuneCountInString(v.String())
+		case fd.Kind() == protoreflect.BytesKind:
+			n = len(v.Bytes())
+		}
+		if rules.MinLen != nil && uint64(n) < rules.GetMinLen() {
+			return fmt.Sprintf("length must be at least %d", rules.GetMinLen())
+		}
+		if rules.MaxLen != nil && uint64(n) > rules.GetMaxLen() {
+			return fmt.Sprintf("length must be at most %d", rules.GetMaxLen())
+		}
+	}
+	if fd.IsList() || fd.IsMap() {
+		return ""
+	}
+
+	if p := rules.GetPattern(); p != "" && fd.Kind() == protoreflect.StringKind {
+		re, err := compilePattern(p)
+		if err != nil {
+			return fmt.Sprintf("invalid pattern %q: %v", p, err)
+		}
+		if !re.MatchString(v.String()) {
+			return fmt.Sprintf("must match %q", p)
+		}
+	}
+	if rules.Gte != nil || rules.Lte != nil {
+		n, ok := number(fd, v)
+		if ok && rules.Gte != nil && n < rules.GetGte() {
+			return fmt.Sprintf("must be >= %v", rules.GetGte())
+		}
+		if ok && rules.Lte != nil && n > rules.GetLte() {
+			return fmt.Sprintf("must be <= %v", rules.GetLte())
+		}
+	}
+	if rules.GetDefinedOnly() && fd.Kind() == protoreflect.EnumKind {
+		if fd.Enum().Values().ByNumber(v.Enum()) == nil {
+			return fmt.Sprintf("%d is not a defined %s", v.Enum(), fd.Enum().FullName())
+		}
+	}
+	return ""
+}
+
+// number returns the value of a numeric field as float64.
+func number(fd protoreflect.FieldDescriptor, v protoreflect.Value) (float64, bool) {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(v.Int()), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(v.Uint()), true
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// ValidateFilter rejects requests violating the field rules declared in the .proto
+// file with RetServerValidateFail, which the HTTP protocol maps to 400.
+func ValidateFilter(ctx context.Context, req interface{}, next filter.ServerHandleFunc) (rsp interface{}, err error) {
+	if m, ok := req.(proto.Message); ok {
+		if violations := Validate(m); len(violations) > 0 {
+			return nil, errs.New(errs.RetServerValidateFail, "invalid request: "+strings.Join(violations, "; "))
+		}
+	}
+	return next(ctx, req)
+}
+
+// ValidateClientFilter checks the same rules before sending, with RetClientValidateFail.
+func ValidateClientFilter(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+	if m, ok := req.(proto.Message); ok {
+		if violations := Validate(m); len(violations) > 0 {
+			return errs.NewFrameError(errs.RetClientValidateFail, "invalid request: "+strings.Join(violations, "; "))
+		}
+	}
+	return next(ctx, req, rsp)
+}
+
+func init() {
+	filter.Register("validate", ValidateFilter, ValidateClientFilter)
+}
@@ -0,0 +1,148 @@
+// Command replay sends the requests recorded by the traffic_record filter again and
+// diffs the new responses against the recorded ones:
+//
+//	go run ./examples/filters/replay -target ip://127.0.0.1:8000 \
+//		-meta authorization=secret-token-123 records/traffic.*.jsonl
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"trpc-go-note/examples/filters/common"
+	pb "trpc-go-note/examples/helloworld/pb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/errs"
+)
+
+// replayer calls one RPC with its generated client proxy.
+type replayer struct {
+	newReq func() proto.Message
+	newRsp func() proto.Message
+	call   func(ctx context.Context, req proto.Message, opts ...client.Option) (proto.Message, error)
+}
+
+// replayers returns the replayable RPCs by name, add the other proxies here.
+func replayers(target string) map[string]replayer {
+	greeter := pb.NewGreeterClientProxy(client.WithTarget(target))
+	return map[string]replayer{
+		"/trpc.helloworld.Greeter/Hello": {
+			newReq: func() proto.Message { return &pb.HelloRequest{} },
+			newRsp: func() proto.Message { return &pb.HelloReply{} },
+			call: func(ctx context.Context, req proto.Message, opts ...client.Option) (proto.Message, error) {
+				return greeter.Hello(ctx, req.(*pb.HelloRequest), opts...)
+			},
+		},
+	}
+}
+
+// metaFlags collects the repeated -meta key=value flags.
+type metaFlags map[string]string
+
+func (m metaFlags) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m metaFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("want key=value, got %q", s)
+	}
+	m[k] = v
+	return nil
+}
+
+func main() {
+	target := flag.String("target", "ip://127.0.0.1:8000", "address of the service")
+	timeout := flag.Duration("timeout", time.Second, "timeout of each request")
+	meta := metaFlags{}
+	flag.Var(meta, "meta", "key=value metadata added to every request, e.g. a credential skipped by recording")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("usage: replay [flags] records/traffic.*.jsonl")
+	}
+
+	rs := replayers(*target)
+	var total, same, diff, skipped int
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64<<10), 64<<20)
+		for scanner.Scan() {
+			var rec common.TrafficRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+			total++
+			r, ok := rs[rec.RPC]
+			if !ok {
+				skipped++
+				continue
+			}
+			if d := replay(r, &rec, meta, *timeout); d != "" {
+				diff++
+				fmt.Printf("DIFF %s %s\n%s\n", rec.RPC, rec.Time.Format(time.RFC3339Nano), d)
+			} else {
+				same++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		_ = f.Close()
+	}
+	fmt.Printf("total: %d, same: %d, diff: %d, skipped: %d\n", total, same, diff, skipped)
+}
+
+// replay sends the recorded request and returns the difference of the responses, "" if none.
+func replay(r replayer, rec *common.TrafficRecord, meta metaFlags, timeout time.Duration) string {
+	req := r.newReq()
+	if err := codec.Unmarshal(rec.Serialization, rec.Request, req); err != nil {
+		return "unmarshal recorded request: " + err.Error()
+	}
+	opts := []client.Option{
+		client.WithCallerServiceName(rec.Caller),
+		client.WithMetaData(common.MetaReplay, []byte("1")),
+	}
+	for k, v := range rec.Metadata {
+		if _, ok := meta[k]; !ok {
+			opts = append(opts, client.WithMetaData(k, v))
+		}
+	}
+	for k, v := range meta {
+		opts = append(opts, client.WithMetaData(k, []byte(v)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rsp, err := r.call(ctx, req, opts...)
+
+	if code := int(errs.Code(err)); code != rec.Code {
+		return fmt.Sprintf("  code: %d %q => %d %q", rec.Code, rec.Msg, code, errs.Msg(err))
+	}
+	if err != nil {
+		return ""
+	}
+	want := r.newRsp()
+	if err := codec.Unmarshal(rec.Serialization, rec.Response, want); err != nil {
+		return "unmarshal recorded response: " + err.Error()
+	}
+	if proto.Equal(want, rsp) {
+		return ""
+	}
+	return fmt.Sprintf("  recorded: %s\n  replayed: %s", protojson.Format(want), protojson.Format(rsp))
+}
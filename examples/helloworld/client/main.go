@@ -2,18 +2,47 @@ package main
 
 import (
 	"context"
+	"flag"
+	"time"
 
 	"trpc-go-note/examples/helloworld/pb"
+	_ "trpc-go-note/examples/naming/common" // Import the file discovery
 
+	trpc "trpc.group/trpc-go/trpc-go"
 	"trpc.group/trpc-go/trpc-go/client"
 	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+)
+
+var (
+	count    = flag.Int("n", 1, "number of calls")
+	interval = flag.Duration("interval", time.Second, "interval between calls")
 )
 
 func main() {
-	c := pb.NewGreeterClientProxy(client.WithTarget("ip://127.0.0.1:8000"))
-	rsp, err := c.Hello(context.Background(), &pb.HelloRequest{Msg: "world"})
+	flag.Parse()
+
+	// Load trpc_go.yaml: trpc.helloworld.Greeter is discovered from nodes.yaml, which is
+	// reloaded when edited, e.g. run with -n 60 and change the address meanwhile.
+	cfg, err := trpc.LoadConfig(trpc.ServerConfigPath)
 	if err != nil {
-		log.Error(err)
+		log.Fatal(err)
+	}
+	if err := trpc.Setup(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	c := pb.NewGreeterClientProxy()
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		var node registry.Node
+		rsp, err := c.Hello(context.Background(), &pb.HelloRequest{Msg: "world"}, client.WithSelectorNode(&node))
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		log.Infof("%s from %s", rsp.Msg, node.Address)
 	}
-	log.Info(rsp.Msg)
 }
@@ -7,12 +7,12 @@
 package pb
 
 import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
 	sync "sync"
+	_ "trpc-go-note/examples/filters/validate"
 	unsafe "unsafe"
-
-	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
-	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 )
 
 const (
@@ -114,9 +114,9 @@ var File_helloworld_proto protoreflect.FileDescriptor
 
 const file_helloworld_proto_rawDesc = "" +
 	"\n" +
-	"\x10helloworld.proto\x12\x0ftrpc.helloworld\" \n" +
-	"\fHelloRequest\x12\x10\n" +
-	"\x03msg\x18\x01 \x01(\tR\x03msg\"\x1e\n" +
+	"\x10helloworld.proto\x12\x0ftrpc.helloworld\x1a\x17validate/validate.proto\"*\n" +
+	"\fHelloRequest\x12\x1a\n" +
+	"\x03msg\x18\x01 \x01(\tB\b\xca\xf3\x18\x04\b\x01\x18@R\x03msg\"\x1e\n" +
 	"\n" +
 	"HelloReply\x12\x10\n" +
 	"\x03msg\x18\x01 \x01(\tR\x03msg2P\n" +
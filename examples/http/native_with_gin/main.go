@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 
+	"trpc-go-note/examples/filters/common"
+
 	"github.com/gin-gonic/gin"
 	"trpc.group/trpc-go/trpc-go"
 	trpc_http "trpc.group/trpc-go/trpc-go/http"
@@ -21,8 +23,10 @@ func main() {
 	s := trpc.NewServer()
 
 	// 2. 初始化 Gin Engine
-	// gin.Default() 默认带有 Logger 和 Recovery 中间件
-	g := gin.Default()
+	// gin.Default() 默认带有 Logger 和 Recovery 中间件，这里不用 Gin 的 Recovery，
+	// 而是在外层套 common.RecoveryHandler，panic 和 trpc filter 一样记录到 /cmds/panics
+	g := gin.New()
+	g.Use(gin.Logger())
 
 	// 3. 定义路由和处理函数
 	// Gin 提供了更强大的路由功能，比如分组、中间件等
@@ -66,6 +70,12 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "created", "user": req})
 	})
 
+	// 演示 panic：返回 500，curl http://127.0.0.1:9030/cmds/panics 可以看到指纹和堆栈
+	g.GET("/panic", func(c *gin.Context) {
+		var m map[string]int
+		m["boom"]++
+	})
+
 	// 4. 获取 trpc Service 并注册 Gin
 	serviceName := "trpc.demo.http.MyService"
 	service := s.Service(serviceName)
@@ -74,7 +84,7 @@ func main() {
 	}
 
 	// 核心魔法：将 Gin Engine 注册为 trpc 的 Handler
-	trpc_http.RegisterNoProtocolServiceMux(service, g)
+	trpc_http.RegisterNoProtocolServiceMux(service, common.RecoveryHandler(g))
 
 	// 5. 启动
 	log.Infof("Server is serving at port 8080...")
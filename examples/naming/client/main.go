@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	pb "trpc-go-note/examples/helloworld/pb"
+	"trpc-go-note/examples/naming/common"
+
+	trpc "trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+)
+
+func main() {
+	// Load trpc_go.yaml: the `client.service` naming config and the `plugins.circuitbreaker` config
+	cfg, err := trpc.LoadConfig(trpc.ServerConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := trpc.Setup(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	proxy := pb.NewGreeterClientProxy()
+	breaker := common.DefaultBreaker()
+
+	// Node b is slow for the first seconds of the server: its calls time out until the
+	// breaker opens, then the traffic goes to a until the probes find b healthy again.
+	for sec := 1; sec <= 12; sec++ {
+		served := map[string]int{}
+		var failed int
+		for end := time.Now().Add(time.Second); time.Now().Before(end); {
+			var node registry.Node
+			_, err := proxy.Hello(context.Background(), &pb.HelloRequest{Msg: "World"}, client.WithSelectorNode(&node))
+			if err != nil {
+				failed++
+			} else {
+				served[node.Address]++
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		fmt.Printf("%2ds served: %v, failed: %d, b: %s\n", sec, served, failed, breaker.State("127.0.0.1:8001"))
+	}
+}
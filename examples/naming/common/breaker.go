@@ -0,0 +1,288 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+	"trpc.group/trpc-go/trpc-go/naming/circuitbreaker"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// BreakerName is the name of SlidingWindowBreaker, selected by `circuitbreaker: sliding_window`
+// in the client config.
+const BreakerName = "sliding_window"
+
+// BreakerState is the state of the breaker of one node.
+type BreakerState int32
+
+// The states of a breaker.
+const (
+	StateClosed   BreakerState = iota // calls pass, results are counted
+	StateOpen                         // the node is skipped until OpenDuration elapses
+	StateHalfOpen                     // a few probe calls decide between closed and open
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig is the `plugins.circuitbreaker.sliding_window` section of trpc_go.yaml.
+//
+//	plugins:
+//	  circuitbreaker:
+//	    sliding_window:
+//	      window: 10s
+//	      buckets: 10
+//	      min_requests: 20
+//	      error_rate: 0.5
+//	      slow_call: 1s
+//	      slow_rate: 0.8
+//	      open_duration: 5s
+//	      probes: 3
+type BreakerConfig struct {
+	Window       time.Duration `yaml:"window"`        // length of the sliding window, default 10s
+	Buckets      int           `yaml:"buckets"`       // buckets of the window, default 10
+	MinRequests  int           `yaml:"min_requests"`  // calls in the window before the rates count, default 20
+	ErrorRate    float64       `yaml:"error_rate"`    // ratio of failed calls which opens, default 0.5
+	SlowCall     time.Duration `yaml:"slow_call"`     // calls lasting longer are slow, default 1s
+	SlowRate     float64       `yaml:"slow_rate"`     // ratio of slow calls which opens, default 0.8
+	OpenDuration time.Duration `yaml:"open_duration"` // time open before probing, default 5s
+	Probes       int           `yaml:"probes"`        // successful probes which close, default 3
+}
+
+// breakerBucket counts the calls of one slice of the window.
+type breakerBucket struct {
+	epoch               int64 // index of the slice since the unix epoch
+	total, errors, slow int
+}
+
+// nodeBreaker is the breaker of one node.
+type nodeBreaker struct {
+	mu      sync.Mutex
+	state   BreakerState
+	buckets []breakerBucket
+	since   time.Time // when the state was entered, or the probes were last granted
+	probes  int       // probes granted in half open
+	passed  int       // probes succeeded in half open
+}
+
+// SlidingWindowBreaker is a circuit breaker counting the failed and the slow calls of each node
+// in a sliding time window. A node opens when either rate exceeds its threshold, is skipped for
+// OpenDuration, then lets Probes calls through: it closes if they all succeed, opens again otherwise.
+//
+// Only the errors reported to the selector are failures: by default the connection, network and
+// timeout errors of the framework, see client.WithShouldErrReportToSelector.
+// Transitions are logged and counted by the circuitbreaker.<state> counters, the state of each
+// node is reported by the circuitbreaker.<address>.state gauge.
+type SlidingWindowBreaker struct {
+	cfg   BreakerConfig
+	width time.Duration // of a bucket
+	nodes sync.Map      // address => *nodeBreaker
+}
+
+// NewSlidingWindowBreaker creates a breaker, zero fields of cfg use the defaults.
+func NewSlidingWindowBreaker(cfg BreakerConfig) *SlidingWindowBreaker {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.Buckets <= 0 {
+		cfg.Buckets = 10
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.ErrorRate <= 0 {
+		cfg.ErrorRate = 0.5
+	}
+	if cfg.SlowCall <= 0 {
+		cfg.SlowCall = time.Second
+	}
+	if cfg.SlowRate <= 0 {
+		cfg.SlowRate = 0.8
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 5 * time.Second
+	}
+	if cfg.Probes <= 0 {
+		cfg.Probes = 3
+	}
+	return &SlidingWindowBreaker{cfg: cfg, width: cfg.Window / time.Duration(cfg.Buckets)}
+}
+
+func (b *SlidingWindowBreaker) node(addr string) *nodeBreaker {
+	if n, ok := b.nodes.Load(addr); ok {
+		return n.(*nodeBreaker)
+	}
+	n, _ := b.nodes.LoadOrStore(addr, &nodeBreaker{buckets: make([]breakerBucket, b.cfg.Buckets)})
+	return n.(*nodeBreaker)
+}
+
+// State returns the state of the node at addr.
+func (b *SlidingWindowBreaker) State(addr string) BreakerState {
+	n := b.node(addr)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state
+}
+
+// Available reports whether a call may be sent to node. In half open it grants the probes,
+// granted again if none is reported within OpenDuration, e.g. when a probe got no result.
+func (b *SlidingWindowBreaker) Available(node *registry.Node) bool {
+	return b.available(node, true)
+}
+
+// Peek reports whether Available would accept node, without granting a probe.
+func (b *SlidingWindowBreaker) Peek(node *registry.Node) bool {
+	return b.available(node, false)
+}
+
+func (b *SlidingWindowBreaker) available(node *registry.Node, grant bool) bool {
+	n := b.node(node.Address)
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.state {
+	case StateClosed:
+		return true
+	case StateOpen:
+		if now.Sub(n.since) < b.cfg.OpenDuration {
+			return false
+		}
+		b.transition(node.Address, n, StateHalfOpen, now)
+	}
+	if n.probes >= b.cfg.Probes {
+		if now.Sub(n.since) < b.cfg.OpenDuration {
+			return false
+		}
+		if !grant {
+			return true
+		}
+		n.probes, n.passed, n.since = 0, 0, now
+	}
+	if grant {
+		n.probes++
+	}
+	return true
+}
+
+// Report counts the result of a call to node.
+func (b *SlidingWindowBreaker) Report(node *registry.Node, cost time.Duration, err error) error {
+	n := b.node(node.Address)
+	failed, slow := err != nil, cost >= b.cfg.SlowCall
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.state {
+	case StateClosed:
+		total, errors, slows := n.add(now, b.width, failed, slow)
+		if total < b.cfg.MinRequests {
+			return nil
+		}
+		if float64(errors)/float64(total) >= b.cfg.ErrorRate || float64(slows)/float64(total) >= b.cfg.SlowRate {
+			log.Infof("[BREAKER] Node: %s, Requests: %d, Errors: %d, Slow: %d", node.Address, total, errors, slows)
+			b.transition(node.Address, n, StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failed || slow {
+			b.transition(node.Address, n, StateOpen, now)
+			return nil
+		}
+		if n.passed++; n.passed >= b.cfg.Probes {
+			b.transition(node.Address, n, StateClosed, now)
+		}
+	}
+	// Calls sent before the node opened are ignored.
+	return nil
+}
+
+// add counts a call in the bucket of now and returns the counts of the window.
+func (n *nodeBreaker) add(now time.Time, width time.Duration, failed, slow bool) (total, errors, slows int) {
+	epoch := now.UnixNano() / int64(width)
+	cur := &n.buckets[epoch%int64(len(n.buckets))]
+	if cur.epoch != epoch {
+		*cur = breakerBucket{epoch: epoch}
+	}
+	cur.total++
+	if failed {
+		cur.errors++
+	}
+	if slow {
+		cur.slow++
+	}
+
+	for _, bk := range n.buckets {
+		if epoch-bk.epoch < int64(len(n.buckets)) {
+			total, errors, slows = total+bk.total, errors+bk.errors, slows+bk.slow
+		}
+	}
+	return total, errors, slows
+}
+
+// transition moves n to the state to, n.mu must be held.
+func (b *SlidingWindowBreaker) transition(addr string, n *nodeBreaker, to BreakerState, now time.Time) {
+	log.Infof("[BREAKER] Node: %s, State: %s => %s", addr, n.state, to)
+	metrics.Counter("circuitbreaker." + to.String()).Incr()
+	metrics.Gauge("circuitbreaker." + addr + ".state").Set(float64(to))
+
+	n.state, n.since, n.probes, n.passed = to, now, 0, 0
+	if to == StateClosed {
+		// Start counting afresh, the calls before the node opened are obsolete.
+		for i := range n.buckets {
+			n.buckets[i] = breakerBucket{}
+		}
+	}
+}
+
+var defaultBreaker atomic.Value // *SlidingWindowBreaker
+
+func init() {
+	b := NewSlidingWindowBreaker(BreakerConfig{})
+	defaultBreaker.Store(b)
+	circuitbreaker.Register(BreakerName, b)
+}
+
+// DefaultBreaker returns the breaker registered as sliding_window.
+func DefaultBreaker() *SlidingWindowBreaker {
+	return defaultBreaker.Load().(*SlidingWindowBreaker)
+}
+
+// BreakerPluginFactory configures the breaker registered as sliding_window. Clients
+// pick the breaker when their config is loaded, after the plugins are set up.
+type BreakerPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *BreakerPluginFactory) Type() string {
+	return "circuitbreaker"
+}
+
+// Setup decodes the config and registers a new breaker.
+func (f *BreakerPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg BreakerConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	b := NewSlidingWindowBreaker(cfg)
+	defaultBreaker.Store(b)
+	circuitbreaker.Register(BreakerName, b)
+	return nil
+}
+
+func init() {
+	plugin.Register(BreakerName, &BreakerPluginFactory{})
+}
@@ -0,0 +1,443 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/naming/discovery"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/naming/selector"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// DNSDiscoveryName is the name of DNSDiscovery, selected by `discovery: dns` in the client config,
+// and of the selector of the dns:// targets replaced by the plugin.
+const DNSDiscoveryName = "dns"
+
+// DNSDiscoveryConfig is the `plugins.discovery.dns` section of trpc_go.yaml.
+//
+//	plugins:
+//	  discovery:
+//	    dns:
+//	      server: 10.0.0.2:53
+//	      min_ttl: 5s
+//	      max_ttl: 5m
+type DNSDiscoveryConfig struct {
+	Server  string        `yaml:"server"`  // ip:port, default the first nameserver of /etc/resolv.conf
+	Timeout time.Duration `yaml:"timeout"` // of a query, default 2s
+	MinTTL  time.Duration `yaml:"min_ttl"` // lower bound of the TTLs and retry interval after a failure, default 5s
+	MaxTTL  time.Duration `yaml:"max_ttl"` // upper bound of the TTLs, default 5m
+	Idle    time.Duration `yaml:"idle"`    // names not listed for longer are no longer refreshed, default 10m
+}
+
+// dnsName is a name resolved by DNSDiscovery.
+type dnsName struct {
+	nodes    atomic.Value // []*registry.Node, the last good result
+	lastUsed int64        // atomic, unix nano
+}
+
+// DNSDiscovery lists the nodes published in DNS. A name is resolved through its SRV records,
+// the nodes of the lowest priority weighted by the SRV weight, 0 counting as 1, or when it has
+// none and the name has a port, e.g. greeter.example.com:8000, through its A/AAAA records. The
+// targets whose addresses cannot be resolved are skipped.
+//
+// Results are cached for their TTL and refreshed in the background, a failed refresh keeps the
+// last good result and is retried after min_ttl. Queries go to Server over UDP, then TCP if the
+// answer is truncated, since the TTLs are not exposed by net.Resolver.
+type DNSDiscovery struct {
+	cfg    DNSDiscoveryConfig
+	flight singleflight.Group // first resolutions of the names
+	names  sync.Map           // name => *dnsName
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewDNSDiscovery creates a discovery, zero fields of cfg use the defaults.
+func NewDNSDiscovery(cfg DNSDiscoveryConfig) *DNSDiscovery {
+	if cfg.Server == "" {
+		cfg.Server = systemNameserver()
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.MinTTL <= 0 {
+		cfg.MinTTL = 5 * time.Second
+	}
+	if cfg.MaxTTL < cfg.MinTTL {
+		cfg.MaxTTL = 5 * time.Minute
+	}
+	if cfg.Idle <= 0 {
+		cfg.Idle = 10 * time.Minute
+	}
+	return &DNSDiscovery{cfg: cfg, closed: make(chan struct{})}
+}
+
+// systemNameserver returns the first nameserver of /etc/resolv.conf, or 127.0.0.1:53.
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// List returns the cached nodes of serviceName, resolving it on the first call.
+func (d *DNSDiscovery) List(serviceName string, opt ...discovery.Option) ([]*registry.Node, error) {
+	v, ok := d.names.Load(serviceName)
+	if !ok {
+		var err error
+		v, err, _ = d.flight.Do(serviceName, func() (interface{}, error) {
+			if v, ok := d.names.Load(serviceName); ok {
+				return v, nil
+			}
+			nodes, ttl, err := d.resolve(serviceName)
+			if err != nil {
+				return nil, err
+			}
+			n := &dnsName{}
+			n.nodes.Store(nodes)
+			d.names.Store(serviceName, n)
+			go d.refresh(serviceName, n, ttl)
+			return n, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	n := v.(*dnsName)
+	atomic.StoreInt64(&n.lastUsed, time.Now().UnixNano())
+
+	// Selectors may modify the nodes.
+	nodes := n.nodes.Load().([]*registry.Node)
+	list := make([]*registry.Node, len(nodes))
+	for i, node := range nodes {
+		c := *node
+		list[i] = &c
+	}
+	return list, nil
+}
+
+// refresh resolves name again when its TTL expires, until it is idle or d is closed.
+func (d *DNSDiscovery) refresh(name string, n *dnsName, ttl time.Duration) {
+	timer := time.NewTimer(ttl)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.closed:
+			return
+		case <-timer.C:
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&n.lastUsed))) > d.cfg.Idle {
+			d.names.Delete(name)
+			return
+		}
+
+		nodes, next, err := d.resolve(name)
+		if err != nil {
+			log.Warnf("[DNS] Refresh %s failed, keeping the last %d nodes: %v",
+				name, len(n.nodes.Load().([]*registry.Node)), err)
+			next = d.cfg.MinTTL
+		} else {
+			n.nodes.Store(nodes)
+		}
+		timer.Reset(next)
+	}
+}
+
+// Close stops the background refreshes.
+func (d *DNSDiscovery) Close() error {
+	d.once.Do(func() { close(d.closed) })
+	return nil
+}
+
+// resolve returns the nodes of name and the TTL of the result.
+func (d *DNSDiscovery) resolve(name string) ([]*registry.Node, time.Duration, error) {
+	host, port := name, ""
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		host, port = h, p
+	}
+	nodes, ttl, err := d.lookupSRV(name, host)
+	if err != nil || len(nodes) == 0 {
+		if port == "" {
+			return nil, 0, fmt.Errorf("dns: no SRV record of %s: %v", host, err)
+		}
+		var ips []net.IP
+		if ips, ttl, err = d.lookupIP(host); err != nil {
+			return nil, 0, err
+		}
+		nodes = nodes[:0]
+		for _, ip := range ips {
+			nodes = append(nodes, &registry.Node{ServiceName: name, Address: net.JoinHostPort(ip.String(), port)})
+		}
+	}
+
+	if ttl < d.cfg.MinTTL {
+		ttl = d.cfg.MinTTL
+	}
+	if ttl > d.cfg.MaxTTL {
+		ttl = d.cfg.MaxTTL
+	}
+	return nodes, ttl, nil
+}
+
+// lookupSRV returns the nodes of the SRV records of host with the lowest priority.
+func (d *DNSDiscovery) lookupSRV(name, host string) ([]*registry.Node, time.Duration, error) {
+	msg, err := d.query(host, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	var (
+		srvs []*dnsmessage.SRVResource
+		ttl  uint32
+	)
+	for _, rr := range msg.Answers {
+		srv, ok := rr.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		if len(srvs) > 0 && srv.Priority > srvs[0].Priority {
+			continue
+		}
+		if len(srvs) > 0 && srv.Priority < srvs[0].Priority {
+			srvs = srvs[:0]
+		}
+		srvs = append(srvs, srv)
+		ttl = minTTL(ttl, rr.Header.TTL)
+	}
+
+	// The addresses of the targets are usually in the additional section.
+	additional := make(map[string][]net.IP)
+	for _, rr := range msg.Additionals {
+		if ip := resourceIP(rr); ip != nil {
+			additional[rr.Header.Name.String()] = append(additional[rr.Header.Name.String()], ip)
+			ttl = minTTL(ttl, rr.Header.TTL)
+		}
+	}
+
+	var (
+		nodes   []*registry.Node
+		lastErr error
+	)
+	for _, srv := range srvs {
+		target := srv.Target.String()
+		ips, ok := additional[target]
+		if !ok {
+			var ipTTL time.Duration
+			if ips, ipTTL, err = d.lookupIP(target); err != nil {
+				// The other targets still serve.
+				log.Warnf("[DNS] Skipped target %s of %s: %v", target, host, err)
+				lastErr = err
+				continue
+			}
+			ttl = minTTL(ttl, uint32(ipTTL/time.Second))
+		}
+		// Weight 0 is a very small chance of selection, not a drained node (RFC 2782).
+		weight := int(srv.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+		for _, ip := range ips {
+			nodes = append(nodes, &registry.Node{
+				ServiceName: name,
+				Address:     net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))),
+				Weight:      weight,
+			})
+		}
+	}
+	if len(nodes) == 0 && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	return nodes, time.Duration(ttl) * time.Second, nil
+}
+
+// lookupIP returns the A and AAAA records of host.
+func (d *DNSDiscovery) lookupIP(host string) ([]net.IP, time.Duration, error) {
+	var (
+		ips     []net.IP
+		ttl     uint32
+		lastErr error
+	)
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := d.query(host, t)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range msg.Answers {
+			if ip := resourceIP(rr); ip != nil {
+				ips = append(ips, ip)
+				ttl = minTTL(ttl, rr.Header.TTL)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("dns: no address of %s: %v", host, lastErr)
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+func resourceIP(rr dnsmessage.Resource) net.IP {
+	switch b := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:])
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:])
+	}
+	return nil
+}
+
+// minTTL returns the lower of two TTLs, 0 standing for none yet.
+func minTTL(a, b uint32) uint32 {
+	if a == 0 || b < a {
+		return b
+	}
+	return a
+}
+
+// query sends a question about name to the server, over TCP if the UDP answer is truncated.
+func (d *DNSDiscovery) query(name string, t dnsmessage.Type) (*dnsmessage.Message, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	req, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.Timeout)
+	defer cancel()
+	msg, err := d.exchange(ctx, "udp", q.Header.ID, req)
+	if err == nil && msg.Truncated {
+		msg, err = d.exchange(ctx, "tcp", q.Header.ID, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dns: query %s %s: %w", t, name, err)
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("dns: query %s %s: %s", t, name, msg.RCode)
+	}
+	return msg, nil
+}
+
+func (d *DNSDiscovery) exchange(ctx context.Context, network string, id uint16, req []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, d.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	var rsp []byte
+	if network == "tcp" {
+		// Messages over TCP are prefixed by their length.
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(req))), req...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		rsp = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, rsp); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		rsp = make([]byte, 4096)
+		n, err := conn.Read(rsp)
+		if err != nil {
+			return nil, err
+		}
+		rsp = rsp[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(rsp); err != nil {
+		return nil, err
+	}
+	if msg.ID != id {
+		return nil, fmt.Errorf("answer id %d, want %d", msg.ID, id)
+	}
+	return &msg, nil
+}
+
+// DNSSelector is Selector listing the nodes with Discovery, the selector of the dns:// targets
+// once the dns plugin is set up, e.g. dns://_greeter._tcp.example.com or dns://greeter.example.com:8000.
+type DNSSelector struct {
+	Selector
+	Discovery discovery.Discovery
+}
+
+// Select returns a node of the name resolved by Discovery.
+func (s *DNSSelector) Select(serviceName string, opt ...selector.Option) (*registry.Node, error) {
+	return s.Selector.Select(serviceName, append([]selector.Option{selector.WithDiscovery(s.Discovery)}, opt...)...)
+}
+
+// DNSDiscoveryPluginFactory creates the discovery registered as dns, and replaces the selector of
+// the dns:// targets, which trpc dials by host name, by a DNSSelector.
+type DNSDiscoveryPluginFactory struct {
+	d *DNSDiscovery
+}
+
+// Type returns the plugin type.
+func (f *DNSDiscoveryPluginFactory) Type() string {
+	return "discovery"
+}
+
+// Setup decodes the config and registers the discovery and the selector.
+func (f *DNSDiscoveryPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg DNSDiscoveryConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	f.d = NewDNSDiscovery(cfg)
+	discovery.Register(DNSDiscoveryName, f.d)
+	selector.Register(DNSDiscoveryName, &DNSSelector{Discovery: f.d})
+	return nil
+}
+
+// Close stops the refreshes when the server stops.
+func (f *DNSDiscoveryPluginFactory) Close() error {
+	if f.d != nil {
+		return f.d.Close()
+	}
+	return nil
+}
+
+func init() {
+	plugin.Register(DNSDiscoveryName, &DNSDiscoveryPluginFactory{})
+}
@@ -0,0 +1,195 @@
+package common
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+)
+
+const testSRVName = "_greeter._tcp.example.local."
+
+func newTestDNS(t *testing.T) *DNSStandIn {
+	t.Helper()
+	dns, err := NewDNSStandIn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dns.Close() })
+	dns.Set("a.example.local.", dnsmessage.TypeA, 1, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+	dns.Set("b.example.local.", dnsmessage.TypeA, 1, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}})
+	return dns
+}
+
+func srv(port uint16, weight uint16, target string) *dnsmessage.SRVResource {
+	return &dnsmessage.SRVResource{Priority: 10, Weight: weight, Port: port, Target: dnsmessage.MustNewName(target)}
+}
+
+// newTestDNSDiscovery returns a discovery asking dns, whose results live between 50ms and 200ms.
+func newTestDNSDiscovery(t *testing.T, dns *DNSStandIn, idle time.Duration) *DNSDiscovery {
+	t.Helper()
+	d := NewDNSDiscovery(DNSDiscoveryConfig{
+		Server:  dns.Addr(),
+		Timeout: time.Second,
+		MinTTL:  50 * time.Millisecond,
+		MaxTTL:  200 * time.Millisecond,
+		Idle:    idle,
+	})
+	t.Cleanup(func() { _ = d.Close() })
+	return d
+}
+
+// addrWeights returns address => weight of nodes.
+func addrWeights(nodes []*registry.Node) map[string]int {
+	m := make(map[string]int, len(nodes))
+	for _, n := range nodes {
+		m[n.Address] = n.Weight
+	}
+	return m
+}
+
+func TestDNSDiscoverySRVToA(t *testing.T) {
+	dns := newTestDNS(t)
+	dns.Set(testSRVName, dnsmessage.TypeSRV, 1,
+		srv(8000, 100, "a.example.local."),
+		srv(8001, 0, "b.example.local."),
+		srv(8002, 100, "missing.example.local."),
+		&dnsmessage.SRVResource{Priority: 20, Weight: 100, Port: 8003, Target: dnsmessage.MustNewName("a.example.local.")},
+	)
+	d := newTestDNSDiscovery(t, dns, time.Minute)
+
+	nodes, err := d.List(testSRVName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The backup of priority 20 and the target without address are left out, weight 0 counts as 1.
+	want := map[string]int{"127.0.0.1:8000": 100, "127.0.0.2:8001": 1}
+	if got := addrWeights(nodes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("nodes %v, want %v", got, want)
+	}
+	for _, n := range nodes {
+		if n.ServiceName != testSRVName {
+			t.Errorf("service name %q, want %q", n.ServiceName, testSRVName)
+		}
+	}
+}
+
+func TestDNSDiscoveryAWithPort(t *testing.T) {
+	dns := newTestDNS(t)
+	d := newTestDNSDiscovery(t, dns, time.Minute)
+
+	nodes, err := d.List("a.example.local:8000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"127.0.0.1:8000": 0}; !reflect.DeepEqual(addrWeights(nodes), want) {
+		t.Fatalf("nodes %v, want %v", addrWeights(nodes), want)
+	}
+	if _, err := d.List("missing.example.local."); err == nil {
+		t.Fatal("listed a name without SRV record nor port")
+	}
+}
+
+func TestDNSDiscoveryTTLCacheAndRefresh(t *testing.T) {
+	dns := newTestDNS(t)
+	dns.Set(testSRVName, dnsmessage.TypeSRV, 1, srv(8000, 100, "a.example.local."))
+	d := newTestDNSDiscovery(t, dns, time.Minute)
+
+	if _, err := d.List(testSRVName); err != nil {
+		t.Fatal(err)
+	}
+	queries := dns.Queries("udp")
+	for i := 0; i < 10; i++ {
+		if _, err := d.List(testSRVName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := dns.Queries("udp"); got != queries {
+		t.Fatalf("%d queries within the TTL, want none", got-queries)
+	}
+
+	// The TTL of 1s is capped by max_ttl to 200ms.
+	dns.Set(testSRVName, dnsmessage.TypeSRV, 1, srv(8000, 100, "a.example.local."), srv(8001, 100, "b.example.local."))
+	waitNodes(t, d, []string{"127.0.0.1:8000", "127.0.0.2:8001"})
+
+	// A failing server keeps the last good nodes.
+	dns.SetFail(true)
+	time.Sleep(300 * time.Millisecond)
+	nodes, err := d.List(testSRVName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("%d nodes while the server fails, want the last 2", len(nodes))
+	}
+}
+
+func TestDNSDiscoveryTCPFallback(t *testing.T) {
+	dns := newTestDNS(t)
+	dns.Set(testSRVName, dnsmessage.TypeSRV, 1, srv(8000, 100, "a.example.local."), srv(8001, 100, "b.example.local."))
+	dns.SetTruncate(true)
+	d := newTestDNSDiscovery(t, dns, time.Minute)
+
+	nodes, err := d.List(testSRVName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("%d nodes, want 2", len(nodes))
+	}
+	if dns.Queries("tcp") == 0 {
+		t.Fatal("no query over TCP after truncated answers")
+	}
+}
+
+func TestDNSDiscoveryIdle(t *testing.T) {
+	dns := newTestDNS(t)
+	dns.Set(testSRVName, dnsmessage.TypeSRV, 1, srv(8000, 100, "a.example.local."))
+	d := newTestDNSDiscovery(t, dns, 100*time.Millisecond)
+
+	if _, err := d.List(testSRVName); err != nil {
+		t.Fatal(err)
+	}
+	// Not listed for longer than idle, the name is dropped at its next refresh.
+	time.Sleep(500 * time.Millisecond)
+	if _, ok := d.names.Load(testSRVName); ok {
+		t.Fatal("idle name still refreshed")
+	}
+	queries := dns.Queries("udp")
+	time.Sleep(300 * time.Millisecond)
+	if got := dns.Queries("udp"); got != queries {
+		t.Fatalf("%d queries for an idle name, want none", got-queries)
+	}
+
+	// Listed again, it is resolved again.
+	if _, err := d.List(testSRVName); err != nil {
+		t.Fatal(err)
+	}
+	if dns.Queries("udp") == queries {
+		t.Fatal("idle name listed again without query")
+	}
+}
+
+// waitNodes waits for the discovery to list the addresses of want.
+func waitNodes(t *testing.T, d *DNSDiscovery, want []string) {
+	t.Helper()
+	var got []string
+	for end := time.Now().Add(2 * time.Second); time.Now().Before(end); time.Sleep(20 * time.Millisecond) {
+		nodes, err := d.List(testSRVName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = got[:0]
+		for _, n := range nodes {
+			got = append(got, n.Address)
+		}
+		sort.Strings(got)
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+	}
+	t.Fatalf("nodes %v, want %v", got, want)
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// DNSStandIn is an in-process DNS server answering from the records set by the caller, standing
+// for the DNS server of the environment in the examples and the tests of DNSDiscovery. It serves
+// UDP and TCP on the same loopback port, its address being the server of DNSDiscoveryConfig.
+type DNSStandIn struct {
+	udp net.PacketConn
+	tcp net.Listener
+
+	mu       sync.Mutex
+	records  map[dnsmessage.Type]map[string][]dnsmessage.Resource // type => name => answers
+	fail     bool                                                 // answers SERVFAIL
+	truncate bool                                                 // answers over UDP are truncated
+	queries  map[string]int                                       // network => queries answered
+}
+
+// NewDNSStandIn starts a stand-in on a free loopback port.
+func NewDNSStandIn() (*DNSStandIn, error) {
+	var (
+		udp net.PacketConn
+		tcp net.Listener
+		err error
+	)
+	// The UDP port may be taken for TCP, try a few ones.
+	for i := 0; i < 10; i++ {
+		if udp, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			return nil, err
+		}
+		if tcp, err = net.Listen("tcp", udp.LocalAddr().String()); err == nil {
+			break
+		}
+		_ = udp.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &DNSStandIn{
+		udp:     udp,
+		tcp:     tcp,
+		records: make(map[dnsmessage.Type]map[string][]dnsmessage.Resource),
+		queries: make(map[string]int),
+	}
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+// Addr returns the ip:port of the stand-in.
+func (s *DNSStandIn) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// Close stops the stand-in.
+func (s *DNSStandIn) Close() error {
+	_ = s.tcp.Close()
+	return s.udp.Close()
+}
+
+// Set replaces the answers of name and type, name being fully qualified, e.g. a.example.local.
+func (s *DNSStandIn) Set(name string, t dnsmessage.Type, ttl uint32, answers ...dnsmessage.ResourceBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rrs := make([]dnsmessage.Resource, 0, len(answers))
+	for _, body := range answers {
+		rrs = append(rrs, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: t, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   body,
+		})
+	}
+	if s.records[t] == nil {
+		s.records[t] = make(map[string][]dnsmessage.Resource)
+	}
+	s.records[t][name] = rrs
+}
+
+// SetFail makes the stand-in answer SERVFAIL, or the records again.
+func (s *DNSStandIn) SetFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+// SetTruncate makes the stand-in answer over UDP without records and with the TC bit set, so
+// that the clients ask again over TCP.
+func (s *DNSStandIn) SetTruncate(truncate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncate = truncate
+}
+
+// Queries returns the number of queries answered over network, udp or tcp.
+func (s *DNSStandIn) Queries(network string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[network]
+}
+
+func (s *DNSStandIn) serveUDP() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if rsp := s.answer("udp", buf[:n]); rsp != nil {
+			_, _ = s.udp.WriteTo(rsp, addr)
+		}
+	}
+}
+
+func (s *DNSStandIn) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			// Messages over TCP are prefixed by their length.
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			if rsp := s.answer("tcp", req); rsp != nil {
+				_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(rsp))), rsp...))
+			}
+		}()
+	}
+}
+
+// answer returns the packed answer to req, nil if req is not a valid question.
+func (s *DNSStandIn) answer(network string, req []byte) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(req); err != nil || len(q.Questions) != 1 {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.queries[network]++
+	question := q.Questions[0]
+	rsp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, Authoritative: true},
+		Questions: q.Questions,
+	}
+	switch {
+	case s.fail:
+		rsp.RCode = dnsmessage.RCodeServerFailure
+	case s.truncate && network == "udp":
+		rsp.Truncated = true
+	default:
+		rsp.Answers = s.records[question.Type][question.Name.String()]
+	}
+	s.mu.Unlock()
+
+	b, err := rsp.Pack()
+	if err != nil {
+		log.Errorf("[DNS] Stand-in answer of %s failed: %v", question.Name, err)
+		return nil
+	}
+	return b
+}
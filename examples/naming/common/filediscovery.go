@@ -0,0 +1,200 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/naming/discovery"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// FileDiscoveryName is the name of FileDiscovery, selected by `discovery: file` in the client config.
+const FileDiscoveryName = "file"
+
+// FileDiscoveryConfig is the `plugins.discovery.file` section of trpc_go.yaml.
+//
+//	plugins:
+//	  discovery:
+//	    file:
+//	      path: ./nodes.yaml
+type FileDiscoveryConfig struct {
+	Path string `yaml:"path"` // default ./nodes.yaml
+}
+
+// FileNode is a node in the file of FileDiscovery, which maps the service names to their
+// nodes, in YAML or JSON:
+//
+//	trpc.helloworld.Greeter:
+//	  - address: 127.0.0.1:8000
+//	    weight: 100
+//	    metadata:
+//	      zone: a
+type FileNode struct {
+	Address  string                 `yaml:"address"`
+	Network  string                 `yaml:"network"`  // default from the client config
+	Protocol string                 `yaml:"protocol"` // default from the client config
+	Weight   *int                   `yaml:"weight"`   // default 100, 0 drains the node
+	SetName  string                 `yaml:"set_name"`
+	Metadata map[string]interface{} `yaml:"metadata"`
+}
+
+// FileDiscovery lists the nodes of a local file, reloaded when it changes. A file which
+// cannot be read or parsed, or is empty, is logged and the previous nodes are kept.
+type FileDiscovery struct {
+	path     string
+	services atomic.Value // map[string][]FileNode
+	watcher  *fsnotify.Watcher
+}
+
+// NewFileDiscovery loads the file at path and watches it.
+func NewFileDiscovery(path string) (*FileDiscovery, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &FileDiscovery{path: path}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	// Editors often replace the file rather than write it, watch the directory.
+	if d.watcher, err = fsnotify.NewWatcher(); err != nil {
+		return nil, err
+	}
+	if err := d.watcher.Add(filepath.Dir(path)); err != nil {
+		_ = d.watcher.Close()
+		return nil, err
+	}
+	go d.watch()
+	return d, nil
+}
+
+func (d *FileDiscovery) load() error {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	var services map[string][]FileNode
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return fmt.Errorf("parse %s: %w", d.path, err)
+	}
+	if len(services) == 0 {
+		// Also seen while the file is rewritten, between the truncation and the write.
+		return fmt.Errorf("parse %s: no service", d.path)
+	}
+	for name, nodes := range services {
+		for _, n := range nodes {
+			if n.Address == "" {
+				return fmt.Errorf("parse %s: %s: node without address", d.path, name)
+			}
+		}
+	}
+	d.services.Store(services)
+	return nil
+}
+
+func (d *FileDiscovery) watch() {
+	for {
+		select {
+		case ev, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != d.path || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := d.load(); err != nil {
+				log.Errorf("[DISCOVERY] Reload failed, keeping the previous nodes: %v", err)
+				continue
+			}
+			log.Infof("[DISCOVERY] Reloaded File: %s", d.path)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("[DISCOVERY] Watch %s failed: %v", d.path, err)
+		}
+	}
+}
+
+// List returns the nodes of serviceName in the file. The nodes are new at each call,
+// selectors may modify them.
+func (d *FileDiscovery) List(serviceName string, opt ...discovery.Option) ([]*registry.Node, error) {
+	nodes := d.services.Load().(map[string][]FileNode)[serviceName]
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no node of %s in %s", serviceName, d.path)
+	}
+	list := make([]*registry.Node, 0, len(nodes))
+	for _, n := range nodes {
+		node := &registry.Node{
+			ServiceName: serviceName,
+			Address:     n.Address,
+			Network:     n.Network,
+			Protocol:    n.Protocol,
+			Weight:      100,
+			SetName:     n.SetName,
+		}
+		if n.Weight != nil {
+			node.Weight = *n.Weight
+		}
+		if len(n.Metadata) > 0 {
+			node.Metadata = make(map[string]interface{}, len(n.Metadata))
+			for k, v := range n.Metadata {
+				node.Metadata[k] = v
+			}
+		}
+		list = append(list, node)
+	}
+	return list, nil
+}
+
+// Close stops watching the file.
+func (d *FileDiscovery) Close() error {
+	return d.watcher.Close()
+}
+
+// FileDiscoveryPluginFactory creates the discovery registered as file.
+type FileDiscoveryPluginFactory struct {
+	d *FileDiscovery
+}
+
+// Type returns the plugin type.
+func (f *FileDiscoveryPluginFactory) Type() string {
+	return "discovery"
+}
+
+// Setup decodes the config, loads the file and registers the discovery.
+func (f *FileDiscoveryPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg FileDiscoveryConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	if cfg.Path == "" {
+		cfg.Path = "./nodes.yaml"
+	}
+	d, err := NewFileDiscovery(cfg.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	f.d = d
+	discovery.Register(FileDiscoveryName, d)
+	return nil
+}
+
+// Close stops watching the file when the server stops.
+func (f *FileDiscoveryPluginFactory) Close() error {
+	if f.d != nil {
+		return f.d.Close()
+	}
+	return nil
+}
+
+func init() {
+	plugin.Register(FileDiscoveryName, &FileDiscoveryPluginFactory{})
+}
@@ -0,0 +1,194 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/naming/loadbalance"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// P2CName is the name of P2CBalancer, selected by `loadbalance: p2c_ewma` in the client config.
+const P2CName = "p2c_ewma"
+
+// P2CConfig is the `plugins.loadbalance.p2c_ewma` section of trpc_go.yaml.
+//
+//	plugins:
+//	  loadbalance:
+//	    p2c_ewma:
+//	      decay: 10s
+//	      error_penalty: 1s
+type P2CConfig struct {
+	Decay        time.Duration `yaml:"decay"`         // time constant of the moving average, default 10s
+	ErrorPenalty time.Duration `yaml:"error_penalty"` // latency of a failed call returning faster, default 1s
+}
+
+// p2cNode is the load of one node.
+type p2cNode struct {
+	inflight int64 // atomic
+
+	mu      sync.Mutex
+	ewma    float64   // peak EWMA of the latency, in ns
+	last    time.Time // of the last sample
+	sampled bool      // false until the first sample, ewma is then unknown
+}
+
+// observe adds a latency sample: a higher one replaces the average at once, a lower one
+// is averaged with a weight decaying with the time since the last sample.
+func (n *p2cNode) observe(rtt float64, now time.Time, decay time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if rtt > n.ewma {
+		n.ewma = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(n.last)) / float64(decay))
+		n.ewma = n.ewma*w + rtt*(1-w)
+	}
+	n.last = now
+	n.sampled = true
+}
+
+// latency returns the average latency decayed to now, false if the node has no sample yet.
+func (n *p2cNode) latency(now time.Time, decay time.Duration) (float64, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.sampled {
+		return 0, false
+	}
+	return n.ewma * math.Exp(-float64(now.Sub(n.last))/float64(decay)), true
+}
+
+// P2CBalancer picks two random nodes and selects the one with the lower peak EWMA latency
+// multiplied by the calls in flight, the power of two choices keeps it cheap and avoids
+// sending every call to the same node.
+//
+// The latency and the end of the calls come from Report, called by Selector with the
+// feedback it gives to the circuit breaker: the balancer needs the naming selector. A failed
+// call counts at least error_penalty, so that a node failing fast is not preferred.
+type P2CBalancer struct {
+	cfg   P2CConfig
+	nodes sync.Map // address => *p2cNode
+}
+
+// NewP2CBalancer creates a balancer, zero fields of cfg use the defaults.
+func NewP2CBalancer(cfg P2CConfig) *P2CBalancer {
+	if cfg.Decay <= 0 {
+		cfg.Decay = 10 * time.Second
+	}
+	if cfg.ErrorPenalty <= 0 {
+		cfg.ErrorPenalty = time.Second
+	}
+	return &P2CBalancer{cfg: cfg}
+}
+
+func (b *P2CBalancer) node(addr string) *p2cNode {
+	if n, ok := b.nodes.Load(addr); ok {
+		return n.(*p2cNode)
+	}
+	n, _ := b.nodes.LoadOrStore(addr, &p2cNode{last: time.Now()})
+	return n.(*p2cNode)
+}
+
+// score is the expected wait of a call sent now: the average latency, decaying to 0 while the
+// node gets no call so that a node once slow is tried again, times the calls in flight. A node
+// without sample, e.g. new or restarted, has the latency of its peers, 1ns if they have none,
+// so that the calls in flight still count and a burst does not all go to it.
+func (b *P2CBalancer) score(n *p2cNode, list []*registry.Node, now time.Time) float64 {
+	ewma, ok := n.latency(now, b.cfg.Decay)
+	if !ok {
+		ewma = b.peerLatency(list, now)
+	}
+	return ewma * float64(atomic.LoadInt64(&n.inflight)+1)
+}
+
+// peerLatency returns the mean latency of the nodes of list with samples, 1ns if none.
+func (b *P2CBalancer) peerLatency(list []*registry.Node, now time.Time) float64 {
+	var (
+		sum   float64
+		count int
+	)
+	for _, node := range list {
+		if l, ok := b.node(node.Address).latency(now, b.cfg.Decay); ok {
+			sum += l
+			count++
+		}
+	}
+	if count == 0 || sum == 0 {
+		return 1
+	}
+	return sum / float64(count)
+}
+
+// Select returns the less loaded of two random nodes of list.
+func (b *P2CBalancer) Select(serviceName string, list []*registry.Node, opt ...loadbalance.Option) (*registry.Node, error) {
+	var picked *registry.Node
+	switch len(list) {
+	case 0:
+		return nil, loadbalance.ErrNoServerAvailable
+	case 1:
+		picked = list[0]
+	default:
+		i := rand.Intn(len(list))
+		j := rand.Intn(len(list) - 1)
+		if j >= i {
+			j++
+		}
+		now := time.Now()
+		picked = list[i]
+		if b.score(b.node(list[j].Address), list, now) < b.score(b.node(picked.Address), list, now) {
+			picked = list[j]
+		}
+	}
+	atomic.AddInt64(&b.node(picked.Address).inflight, 1)
+	return picked, nil
+}
+
+// Report ends a call to node selected by Select and adds its latency.
+func (b *P2CBalancer) Report(node *registry.Node, cost time.Duration, err error) error {
+	n := b.node(node.Address)
+	if atomic.AddInt64(&n.inflight, -1) < 0 {
+		// Not selected by Select, e.g. the balancer was replaced.
+		atomic.AddInt64(&n.inflight, 1)
+	}
+	if err == errNotGranted {
+		// Selected but not called.
+		return nil
+	}
+	if err != nil && cost < b.cfg.ErrorPenalty {
+		// Failing fast must not make the node look attractive.
+		cost = b.cfg.ErrorPenalty
+	}
+	n.observe(float64(cost), time.Now(), b.cfg.Decay)
+	return nil
+}
+
+func init() {
+	loadbalance.Register(P2CName, NewP2CBalancer(P2CConfig{}))
+}
+
+// P2CPluginFactory configures the balancer registered as p2c_ewma.
+type P2CPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *P2CPluginFactory) Type() string {
+	return "loadbalance"
+}
+
+// Setup decodes the config and registers a new balancer.
+func (f *P2CPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg P2CConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	loadbalance.Register(P2CName, NewP2CBalancer(cfg))
+	return nil
+}
+
+func init() {
+	plugin.Register(P2CName, &P2CPluginFactory{})
+}
@@ -0,0 +1,465 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	trpc "trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/admin"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/naming/discovery"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// RegistryName is the name of the registry plugin registering the services in a RegistryServer,
+// and of RegistryDiscovery, selected by `discovery: embedded` in the client config.
+const RegistryName = "embedded"
+
+// RegistryConfig is the `plugins.registry.embedded` section of trpc_go.yaml.
+//
+//	plugins:
+//	  registry:
+//	    embedded:
+//	      serve: true # host the registry on the admin server
+//	      ttl: 10s
+//	      services:
+//	        - name: trpc.helloworld.Greeter.a
+//	          register_as: trpc.helloworld.Greeter
+type RegistryConfig struct {
+	Address   string                  `yaml:"address"`   // url of the registry, unused with serve
+	Serve     bool                    `yaml:"serve"`     // hosts a RegistryServer on the admin server
+	MaxWait   time.Duration           `yaml:"max_wait"`  // of the long polls, with serve
+	TTL       time.Duration           `yaml:"ttl"`       // of the nodes, default 10s
+	Heartbeat time.Duration           `yaml:"heartbeat"` // default ttl/3
+	Services  []RegistryServiceConfig `yaml:"services"`  // default every service of the server config
+}
+
+// RegistryServiceConfig is a service of the server config registered by the plugin.
+type RegistryServiceConfig struct {
+	Name       string                 `yaml:"name"`        // in the server config
+	RegisterAs string                 `yaml:"register_as"` // name listed to the clients, default name
+	Address    string                 `yaml:"address"`     // default the address the service listens to
+	Weight     *int                   `yaml:"weight"`      // default 100, 0 drains the node
+	SetName    string                 `yaml:"set_name"`
+	Metadata   map[string]interface{} `yaml:"metadata"`
+}
+
+// RegistryClient registers a service in a RegistryServer when it serves, renews it with
+// heartbeats, and deregisters it when it closes. A registration failing, e.g. because the
+// registry is not up yet, is retried by the heartbeats instead of failing the service.
+type RegistryClient struct {
+	url       string
+	node      RegistryNode // without address when it is the one of the service
+	heartbeat time.Duration
+	client    *http.Client
+	local     *RegistryServer // the registry hosted by this process, called directly rather than by url
+
+	mu         sync.Mutex
+	registered RegistryNode
+	stop       chan struct{} // of the heartbeats, nil when not registered
+}
+
+// NewRegistryClient creates a client registering node at url, the url of a RegistryServer.
+func NewRegistryClient(url string, node RegistryNode, ttl, heartbeat time.Duration) *RegistryClient {
+	if ttl <= 0 {
+		ttl = 10 * time.Second
+	}
+	if heartbeat <= 0 || heartbeat >= ttl {
+		heartbeat = ttl / 3
+	}
+	node.TTL = ttl.String()
+	return &RegistryClient{
+		url:       url,
+		node:      node,
+		heartbeat: heartbeat,
+		client:    &http.Client{Timeout: heartbeat},
+	}
+}
+
+// Register registers the node and starts the heartbeats, called by the service on Serve.
+func (c *RegistryClient) Register(service string, opt ...registry.Option) error {
+	opts := &registry.Options{}
+	for _, o := range opt {
+		o(opts)
+	}
+	node := c.node
+	if node.Service == "" {
+		node.Service = service
+	}
+	if node.Address == "" {
+		node.Address = opts.Address
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return nil
+	}
+	if err := c.put(node); err != nil {
+		log.Warnf("[REGISTRY] Register %s failed, retrying: %v", node.Service, err)
+	}
+	c.registered = node
+	c.stop = make(chan struct{})
+	go c.beat(node, c.stop)
+	return nil
+}
+
+// beat renews node until stop is closed, sooner after a failure.
+func (c *RegistryClient) beat(node RegistryNode, stop chan struct{}) {
+	timer := time.NewTimer(c.heartbeat)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		next := c.heartbeat
+		if err := c.put(node); err != nil {
+			log.Warnf("[REGISTRY] Heartbeat of %s failed: %v", node.Service, err)
+			next = time.Second
+		}
+		timer.Reset(next)
+	}
+}
+
+// Deregister stops the heartbeats and deregisters the node, called by the service on Close.
+func (c *RegistryClient) Deregister(service string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop == nil {
+		return nil
+	}
+	close(c.stop)
+	c.stop = nil
+
+	if c.local != nil {
+		c.local.deregister(c.registered.Service, c.registered.Address)
+		return nil
+	}
+	q := url.Values{"service": {c.registered.Service}, "address": {c.registered.Address}}
+	req, err := http.NewRequest(http.MethodDelete, c.url+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return registryDo(c.client, req, nil)
+}
+
+func (c *RegistryClient) put(node RegistryNode) error {
+	if c.local != nil {
+		ttl, err := time.ParseDuration(node.TTL)
+		if err != nil {
+			return err
+		}
+		c.local.register(node, ttl)
+		return nil
+	}
+	body, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return registryDo(c.client, req, nil)
+}
+
+// registryDo sends req and decodes the answer into rsp, or returns its error.
+func registryDo(client *http.Client, req *http.Request, rsp interface{}) error {
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		return fmt.Errorf("registry: %s: %s", r.Status, e.Error)
+	}
+	if rsp == nil {
+		_, _ = io.Copy(io.Discard, r.Body)
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(rsp)
+}
+
+// RegistryDiscoveryConfig is the `plugins.discovery.embedded` section of trpc_go.yaml.
+//
+//	plugins:
+//	  discovery:
+//	    embedded:
+//	      address: http://127.0.0.1:9029/cmds/registry
+type RegistryDiscoveryConfig struct {
+	Address string        `yaml:"address"` // url of the registry
+	Wait    time.Duration `yaml:"wait"`    // of a long poll, default 30s
+	Idle    time.Duration `yaml:"idle"`    // services not listed for longer are no longer watched, default 10m
+}
+
+// registryWatch is a service watched by RegistryDiscovery.
+type registryWatch struct {
+	nodes    atomic.Value // []*registry.Node
+	index    uint64       // of nodes, used by the watching goroutine only
+	lastUsed int64        // atomic, unix nano
+}
+
+// RegistryDiscovery lists the nodes of a RegistryServer. A service is fetched on the first call,
+// then watched with long polls which return as soon as it changes. While the registry cannot be
+// reached the last nodes are kept.
+type RegistryDiscovery struct {
+	cfg      RegistryDiscoveryConfig
+	client   *http.Client
+	flight   singleflight.Group // first fetches of the services
+	services sync.Map           // name => *registryWatch
+	ctx      context.Context    // cancelled on Close
+	cancel   context.CancelFunc
+}
+
+// NewRegistryDiscovery creates a discovery, zero fields of cfg use the defaults.
+func NewRegistryDiscovery(cfg RegistryDiscoveryConfig) *RegistryDiscovery {
+	if cfg.Wait <= 0 {
+		cfg.Wait = 30 * time.Second
+	}
+	if cfg.Idle <= 0 {
+		cfg.Idle = 10 * time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RegistryDiscovery{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Wait + 10*time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// List returns the nodes of serviceName, fetching it on the first call.
+func (d *RegistryDiscovery) List(serviceName string, opt ...discovery.Option) ([]*registry.Node, error) {
+	v, ok := d.services.Load(serviceName)
+	if !ok {
+		var err error
+		v, err, _ = d.flight.Do(serviceName, func() (interface{}, error) {
+			if v, ok := d.services.Load(serviceName); ok {
+				return v, nil
+			}
+			nodes, index, err := d.fetch(serviceName, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+			w := &registryWatch{index: index, lastUsed: time.Now().UnixNano()}
+			w.nodes.Store(nodes)
+			d.services.Store(serviceName, w)
+			go d.watch(serviceName, w)
+			return w, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w := v.(*registryWatch)
+	atomic.StoreInt64(&w.lastUsed, time.Now().UnixNano())
+
+	// Selectors may modify the nodes.
+	nodes := w.nodes.Load().([]*registry.Node)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no node of %s registered", serviceName)
+	}
+	list := make([]*registry.Node, len(nodes))
+	for i, node := range nodes {
+		c := *node
+		list[i] = &c
+	}
+	return list, nil
+}
+
+// watch long polls the changes of name until it is idle or d is closed.
+func (d *RegistryDiscovery) watch(name string, w *registryWatch) {
+	for d.ctx.Err() == nil {
+		if time.Since(time.Unix(0, atomic.LoadInt64(&w.lastUsed))) > d.cfg.Idle {
+			d.services.Delete(name)
+			return
+		}
+		nodes, index, err := d.fetch(name, w.index, d.cfg.Wait)
+		if err != nil {
+			if d.ctx.Err() != nil {
+				return
+			}
+			log.Warnf("[REGISTRY] Watch %s failed, keeping the last %d nodes: %v",
+				name, len(w.nodes.Load().([]*registry.Node)), err)
+			select {
+			case <-d.ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if index != w.index {
+			w.nodes.Store(nodes)
+			w.index = index
+			log.Infof("[REGISTRY] Service: %s, Nodes: %d", name, len(nodes))
+		}
+	}
+}
+
+// fetch returns the nodes of name and their index, after a change from index or wait.
+func (d *RegistryDiscovery) fetch(name string, index uint64, wait time.Duration) ([]*registry.Node, uint64, error) {
+	q := url.Values{"service": {name}}
+	if wait > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", wait.String())
+	}
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodGet, d.cfg.Address+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rsp struct {
+		Index uint64         `json:"index"`
+		Nodes []RegistryNode `json:"nodes"`
+	}
+	if err := registryDo(d.client, req, &rsp); err != nil {
+		return nil, 0, err
+	}
+
+	nodes := make([]*registry.Node, len(rsp.Nodes))
+	for i, n := range rsp.Nodes {
+		nodes[i] = &registry.Node{
+			ServiceName: n.Service,
+			Address:     n.Address,
+			Network:     n.Network,
+			Protocol:    n.Protocol,
+			Weight:      n.Weight,
+			SetName:     n.SetName,
+			Metadata:    n.Metadata,
+		}
+	}
+	return nodes, rsp.Index, nil
+}
+
+// Close stops the watches.
+func (d *RegistryDiscovery) Close() error {
+	d.cancel()
+	return nil
+}
+
+// RegistryPluginFactory registers the services of the server config in a RegistryServer, hosted
+// on the admin server with serve.
+type RegistryPluginFactory struct {
+	srv *RegistryServer
+}
+
+// Type returns the plugin type.
+func (f *RegistryPluginFactory) Type() string {
+	return "registry"
+}
+
+// Setup decodes the config, mounts the registry on the admin server with serve, and registers a
+// RegistryClient for each service, used by the service when it is created after the plugins.
+func (f *RegistryPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg RegistryConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	serverCfg := trpc.GlobalConfig().Server
+	if cfg.Serve {
+		if serverCfg.Admin.Port == 0 {
+			return fmt.Errorf("%s: serve needs server.admin.port", name)
+		}
+		f.srv = NewRegistryServer(RegistryServerConfig{TTL: cfg.TTL, MaxWait: cfg.MaxWait})
+		admin.HandleFunc(RegistryPath, f.srv.ServeHTTP)
+	} else if cfg.Address == "" {
+		return fmt.Errorf("%s: address required without serve", name)
+	}
+
+	services := cfg.Services
+	if len(services) == 0 {
+		for _, s := range serverCfg.Service {
+			services = append(services, RegistryServiceConfig{Name: s.Name})
+		}
+	}
+	for _, s := range services {
+		weight := 100
+		if s.Weight != nil {
+			weight = *s.Weight
+		}
+		var svc *trpc.ServiceConfig
+		for _, c := range serverCfg.Service {
+			if c.Name == s.Name {
+				svc = c
+			}
+		}
+		if svc == nil {
+			return fmt.Errorf("%s: no service %s in the server config", name, s.Name)
+		}
+		rc := NewRegistryClient(cfg.Address, RegistryNode{
+			Service:  s.RegisterAs,
+			Address:  s.Address,
+			Network:  svc.Network,
+			Protocol: svc.Protocol,
+			Weight:   weight,
+			SetName:  s.SetName,
+			Metadata: s.Metadata,
+		}, cfg.TTL, cfg.Heartbeat)
+		// With serve, registered directly: the admin server may not listen yet when the services serve.
+		rc.local = f.srv
+		registry.Register(s.Name, rc)
+	}
+	return nil
+}
+
+// Close stops the hosted registry when the server stops.
+func (f *RegistryPluginFactory) Close() error {
+	if f.srv != nil {
+		return f.srv.Close()
+	}
+	return nil
+}
+
+// RegistryDiscoveryPluginFactory creates the discovery registered as embedded.
+type RegistryDiscoveryPluginFactory struct {
+	d *RegistryDiscovery
+}
+
+// Type returns the plugin type.
+func (f *RegistryDiscoveryPluginFactory) Type() string {
+	return "discovery"
+}
+
+// Setup decodes the config and registers the discovery.
+func (f *RegistryDiscoveryPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg RegistryDiscoveryConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	if cfg.Address == "" {
+		return fmt.Errorf("%s: address required", name)
+	}
+	f.d = NewRegistryDiscovery(cfg)
+	discovery.Register(RegistryName, f.d)
+	return nil
+}
+
+// Close stops the watches when the server stops.
+func (f *RegistryDiscoveryPluginFactory) Close() error {
+	if f.d != nil {
+		return f.d.Close()
+	}
+	return nil
+}
+
+func init() {
+	plugin.Register(RegistryName, &RegistryPluginFactory{})
+	plugin.Register(RegistryName, &RegistryDiscoveryPluginFactory{})
+}
@@ -0,0 +1,310 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+)
+
+// RegistryPath is the path of the registry, on the admin server or standalone.
+const RegistryPath = "/cmds/registry"
+
+// RegistryServerConfig configures RegistryServer.
+type RegistryServerConfig struct {
+	TTL     time.Duration `yaml:"ttl"`      // of the nodes registered without ttl, default 10s
+	MaxWait time.Duration `yaml:"max_wait"` // of a long poll, default 50s, below the 60s write timeout of admin
+}
+
+// RegistryNode is a node registered in RegistryServer, as sent by the servers and listed to the clients.
+type RegistryNode struct {
+	Service  string                 `json:"service"`
+	Address  string                 `json:"address"`
+	Network  string                 `json:"network,omitempty"`
+	Protocol string                 `json:"protocol,omitempty"`
+	Weight   int                    `json:"weight"` // 100 when omitted, 0 drains the node
+	SetName  string                 `json:"set_name,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	TTL      string                 `json:"ttl,omitempty"` // when registering, e.g. 10s
+}
+
+// registryEntry is a registered node.
+type registryEntry struct {
+	node    RegistryNode
+	expires time.Time
+}
+
+// registryService is the nodes of a service.
+type registryService struct {
+	nodes   map[string]*registryEntry // address => entry
+	index   uint64                    // of the last change
+	changed chan struct{}             // closed and replaced at each change, wakes up the long polls
+}
+
+// RegistryServer keeps the nodes registered by the servers until they deregister or miss their
+// heartbeats for the TTL. It is an http.Handler, mounted on the admin server with
+// admin.HandleFunc(RegistryPath, s.ServeHTTP) or run standalone with ListenAndServe:
+//
+//	PUT    {"service": "...", "address": "ip:port", "ttl": "10s", ...}  registers a node or renews it
+//	DELETE ?service=xxx&address=ip:port                                deregisters a node
+//	GET    ?service=xxx&index=N&wait=30s                               lists the nodes of a service
+//	GET                                                                lists every service
+//
+// Every change of a service increments its index. A GET with wait is a long poll, answered when
+// the index of the service differs from index or after wait, so that clients see the changes
+// at once without polling.
+type RegistryServer struct {
+	cfg RegistryServerConfig
+
+	mu       sync.Mutex
+	services map[string]*registryService
+	index    uint64 // of the last change of any service
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewRegistryServer creates a registry, zero fields of cfg use the defaults.
+func NewRegistryServer(cfg RegistryServerConfig) *RegistryServer {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 10 * time.Second
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = 50 * time.Second
+	}
+	s := &RegistryServer{
+		cfg:      cfg,
+		services: make(map[string]*registryService),
+		closed:   make(chan struct{}),
+	}
+	go s.expire()
+	return s
+}
+
+// ListenAndServe serves the registry alone at addr, on RegistryPath.
+func (s *RegistryServer) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(RegistryPath, s)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-s.closed
+		_ = srv.Close()
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the expiry of the nodes and the standalone server.
+func (s *RegistryServer) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// changeLocked records a change of svc, s.mu held.
+func (s *RegistryServer) changeLocked(svc *registryService) {
+	s.index++
+	svc.index = s.index
+	close(svc.changed)
+	svc.changed = make(chan struct{})
+}
+
+func (s *RegistryServer) serviceLocked(name string) *registryService {
+	svc, ok := s.services[name]
+	if !ok {
+		// Kept when its last node leaves, its index must not go back.
+		svc = &registryService{nodes: make(map[string]*registryEntry), changed: make(chan struct{})}
+		s.services[name] = svc
+	}
+	return svc
+}
+
+// register adds node or renews it, a change only if it is new or differs.
+func (s *RegistryServer) register(node RegistryNode, ttl time.Duration) {
+	node.TTL = ""
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc := s.serviceLocked(node.Service)
+	e, ok := svc.nodes[node.Address]
+	if !ok {
+		e = &registryEntry{}
+		svc.nodes[node.Address] = e
+		log.Infof("[REGISTRY] Registered Service: %s, Node: %s", node.Service, node.Address)
+		metrics.Counter("registry.registered").Incr()
+	}
+	e.expires = time.Now().Add(ttl)
+	if !ok || !reflect.DeepEqual(e.node, node) {
+		e.node = node
+		s.changeLocked(svc)
+	}
+}
+
+// deregister removes a node, false if it is not registered.
+func (s *RegistryServer) deregister(service, address string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc, ok := s.services[service]
+	if !ok {
+		return false
+	}
+	if _, ok := svc.nodes[address]; !ok {
+		return false
+	}
+	delete(svc.nodes, address)
+	s.changeLocked(svc)
+	log.Infof("[REGISTRY] Deregistered Service: %s, Node: %s", service, address)
+	metrics.Counter("registry.deregistered").Incr()
+	return true
+}
+
+// expire removes the nodes which missed their heartbeats.
+func (s *RegistryServer) expire() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for name, svc := range s.services {
+				for addr, e := range svc.nodes {
+					if now.After(e.expires) {
+						delete(svc.nodes, addr)
+						s.changeLocked(svc)
+						log.Warnf("[REGISTRY] Expired Service: %s, Node: %s", name, addr)
+						metrics.Counter("registry.expired").Incr()
+					}
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// list returns the nodes of service sorted by address, its index, and the channel closed
+// at its next change.
+func (s *RegistryServer) list(service string) ([]RegistryNode, uint64, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Created when unknown, so that a client watching it before the first node registers is woken up.
+	svc := s.serviceLocked(service)
+	nodes := make([]RegistryNode, 0, len(svc.nodes))
+	for _, e := range svc.nodes {
+		nodes = append(nodes, e.node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Address < nodes[j].Address })
+	return nodes, svc.index, svc.changed
+}
+
+// ServeHTTP handles the requests of the servers and the clients.
+func (s *RegistryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		node := RegistryNode{Weight: 100}
+		if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+			registryError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if node.Service == "" || node.Address == "" {
+			registryError(w, http.StatusBadRequest, "service and address required")
+			return
+		}
+		ttl := s.cfg.TTL
+		if node.TTL != "" {
+			d, err := time.ParseDuration(node.TTL)
+			if err != nil || d <= 0 {
+				registryError(w, http.StatusBadRequest, "invalid ttl "+node.TTL)
+				return
+			}
+			ttl = d
+		}
+		s.register(node, ttl)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ttl": ttl.String()})
+	case http.MethodDelete:
+		q := r.URL.Query()
+		if !s.deregister(q.Get("service"), q.Get("address")) {
+			registryError(w, http.StatusNotFound, "node not registered")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{})
+	case http.MethodGet:
+		s.serveList(w, r)
+	default:
+		registryError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveList answers a GET, waiting for a change of the service when asked to.
+func (s *RegistryServer) serveList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	service := q.Get("service")
+	if service == "" {
+		s.mu.Lock()
+		services := make(map[string]int, len(s.services))
+		for name, svc := range s.services {
+			services[name] = len(svc.nodes)
+		}
+		s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"services": services})
+		return
+	}
+
+	var (
+		index uint64
+		wait  time.Duration
+		err   error
+	)
+	if v := q.Get("index"); v != "" {
+		if index, err = strconv.ParseUint(v, 10, 64); err != nil {
+			registryError(w, http.StatusBadRequest, "invalid index "+v)
+			return
+		}
+	}
+	if v := q.Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil {
+			registryError(w, http.StatusBadRequest, "invalid wait "+v)
+			return
+		}
+	}
+	if wait > s.cfg.MaxWait {
+		wait = s.cfg.MaxWait
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		nodes, current, changed := s.list(service)
+		// != rather than >, the index of a restarted registry starts again from 0.
+		if wait <= 0 || current != index {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"index": current, "nodes": nodes})
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			wait = 0
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			wait = 0
+		}
+	}
+}
+
+func registryError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": msg})
+}
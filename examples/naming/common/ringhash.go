@@ -0,0 +1,241 @@
+package common
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/naming/loadbalance"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// RingHashName is the name of RingHashBalancer, selected by `loadbalance: ring_hash` in the client config.
+const RingHashName = "ring_hash"
+
+// RingHashConfig is the `plugins.loadbalance.ring_hash` section of trpc_go.yaml.
+//
+//	plugins:
+//	  loadbalance:
+//	    ring_hash:
+//	      metadata_key: x-user-id
+//	      replicas: 100
+type RingHashConfig struct {
+	MetadataKey string `yaml:"metadata_key"` // metadata holding the hash key, default x-hash-key
+	Replicas    int    `yaml:"replicas"`     // virtual nodes of a node of weight 100, default 100
+}
+
+// hashRing is the ring of one node list.
+type hashRing struct {
+	nodes  map[ringNode]bool // the list the ring was built from
+	points []uint64          // sorted
+	owners []string          // address of the owner of each point
+}
+
+// ringNode identifies a node of the list, two lists of the same ringNodes in any order, e.g.
+// shuffled by a DNS server, share the ring.
+type ringNode struct {
+	addr   string
+	weight int
+}
+
+// ringNodes returns the ringNodes of list sorted, the ring does not depend on the order.
+func ringNodes(list []*registry.Node) []ringNode {
+	nodes := make([]ringNode, len(list))
+	for i, n := range list {
+		nodes[i] = ringNode{addr: n.Address, weight: n.Weight}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].addr != nodes[j].addr {
+			return nodes[i].addr < nodes[j].addr
+		}
+		return nodes[i].weight < nodes[j].weight
+	})
+	return nodes
+}
+
+func (r *hashRing) sameNodes(list []*registry.Node) bool {
+	if len(r.nodes) != len(list) {
+		return false
+	}
+	for _, n := range list {
+		if !r.nodes[ringNode{addr: n.Address, weight: n.Weight}] {
+			return false
+		}
+	}
+	return true
+}
+
+// newHashRing places the virtual nodes of each node at the hashes of its address, so that
+// a node added or removed only moves the keys of its own virtual nodes.
+func newHashRing(nodes []ringNode, replicas int) *hashRing {
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	unweighted := true
+	for _, n := range nodes {
+		if n.weight > 0 {
+			unweighted = false
+			break
+		}
+	}
+	var points []point
+	set := make(map[ringNode]bool, len(nodes))
+	for _, n := range nodes {
+		set[n] = true
+		weight := n.weight
+		if unweighted {
+			weight = 100
+		}
+		if weight <= 0 {
+			// Drained, its keys go to the next nodes of the ring.
+			continue
+		}
+		vnodes := replicas * weight / 100
+		if vnodes < 1 {
+			vnodes = 1
+		}
+		for v := 0; v < vnodes; v++ {
+			points = append(points, point{hash: hashKey(n.addr + "#" + strconv.Itoa(v)), owner: n.addr})
+		}
+	}
+	// Equal hashes are ordered by owner, so that the ring does not depend on the order of nodes.
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+
+	r := &hashRing{nodes: set, points: make([]uint64, len(points)), owners: make([]string, len(points))}
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.hash, p.owner
+	}
+	return r
+}
+
+// get returns the address of the node owning key, the first point clockwise of its hash.
+func (r *hashRing) get(key string) string {
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// hashKey is FNV-1a followed by the murmur3 finalizer, FNV alone spreads close strings
+// like the virtual nodes of an address poorly.
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// RingHashBalancer sends the calls of a key to the same node, for services caching per key.
+// The key is the per-call option client.WithKey, or else the metadata_key metadata of the call,
+// calls without key go to a random node.
+//
+// Nodes have virtual nodes in proportion to their weight, a node of weight 0 is drained and
+// owns no key, unless no node has a weight, then they all count as 100. The ring of each
+// service is rebuilt when the node list changes, e.g. when the discovery updates it or a node is
+// ejected by the circuit breaker, which only moves the keys of the nodes added or removed.
+type RingHashBalancer struct {
+	cfg   RingHashConfig
+	rings sync.Map // service name => *hashRing
+}
+
+// NewRingHashBalancer creates a balancer, zero fields of cfg use the defaults.
+func NewRingHashBalancer(cfg RingHashConfig) *RingHashBalancer {
+	if cfg.MetadataKey == "" {
+		cfg.MetadataKey = "x-hash-key"
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 100
+	}
+	return &RingHashBalancer{cfg: cfg}
+}
+
+// Select returns the node owning the key of the call.
+func (b *RingHashBalancer) Select(serviceName string, list []*registry.Node, opt ...loadbalance.Option) (*registry.Node, error) {
+	if len(list) == 0 {
+		return nil, loadbalance.ErrNoServerAvailable
+	}
+	opts := &loadbalance.Options{}
+	for _, o := range opt {
+		o(opts)
+	}
+	key := opts.Key
+	if key == "" && opts.Ctx != nil {
+		key = string(codec.Message(opts.Ctx).ClientMetaData()[b.cfg.MetadataKey])
+	}
+	if key == "" {
+		return randomWeighted(list), nil
+	}
+
+	r, ok := b.rings.Load(serviceName)
+	if !ok || !r.(*hashRing).sameNodes(list) {
+		r = newHashRing(ringNodes(list), b.cfg.Replicas)
+		b.rings.Store(serviceName, r)
+		log.Debugf("[RINGHASH] Rebuilt Service: %s, Nodes: %d", serviceName, len(list))
+	}
+	owner := r.(*hashRing).get(key)
+	for _, n := range list {
+		if n.Address == owner {
+			return n, nil
+		}
+	}
+	// Only when list repeats an address, which hides another node of the ring.
+	return list[0], nil
+}
+
+// randomWeighted returns a random node of list which is not drained, any if all are.
+func randomWeighted(list []*registry.Node) *registry.Node {
+	var weighted []*registry.Node
+	for _, n := range list {
+		if n.Weight > 0 {
+			weighted = append(weighted, n)
+		}
+	}
+	if len(weighted) == 0 {
+		weighted = list
+	}
+	return weighted[rand.Intn(len(weighted))]
+}
+
+func init() {
+	loadbalance.Register(RingHashName, NewRingHashBalancer(RingHashConfig{}))
+}
+
+// RingHashPluginFactory configures the balancer registered as ring_hash.
+type RingHashPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *RingHashPluginFactory) Type() string {
+	return "loadbalance"
+}
+
+// Setup decodes the config and registers a new balancer.
+func (f *RingHashPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg RingHashConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	loadbalance.Register(RingHashName, NewRingHashBalancer(cfg))
+	return nil
+}
+
+func init() {
+	plugin.Register(RingHashName, &RingHashPluginFactory{})
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/naming/circuitbreaker"
+	"trpc.group/trpc-go/trpc-go/naming/discovery"
+	"trpc.group/trpc-go/trpc-go/naming/loadbalance"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/naming/selector"
+	"trpc.group/trpc-go/trpc-go/naming/servicerouter"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// SelectorName is the name of Selector, as a target scheme, e.g. naming://trpc.helloworld.Greeter,
+// and as the plugin making it the default selector.
+const SelectorName = "naming"
+
+// Selector runs Discovery.List => ServiceRouter.Filter => CircuitBreaker.Available =>
+// LoadBalancer.Select. Unlike TrpcSelector it skips the nodes rejected by the circuit
+// breaker, TrpcSelector only reports the results to it, and reports the results to the
+// load balancers implementing Reporter. Breakers implementing Peeker are asked for
+// Available only for the node selected, after the others were filtered with Peek.
+//
+// trpc ignores the discovery, load balancer and circuit breaker of the client config when the
+// target is set, so clients relying on the config omit the target and make Selector the default
+// with the plugin:
+//
+//	client:
+//	  service:
+//	    - callee: trpc.helloworld.Greeter
+//	      name: 127.0.0.1:8000,127.0.0.1:8001 # without discovery, addresses of the nodes
+//	      circuitbreaker: sliding_window
+//	plugins:
+//	  selector:
+//	    naming: {}
+type Selector struct{}
+
+// Select returns a node of serviceName available to the circuit breaker.
+func (s *Selector) Select(serviceName string, opt ...selector.Option) (*registry.Node, error) {
+	if serviceName == "" {
+		return nil, errors.New("service name empty")
+	}
+	opts := &selector.Options{
+		Discovery:      discovery.DefaultDiscovery,
+		ServiceRouter:  servicerouter.DefaultServiceRouter,
+		LoadBalancer:   loadbalance.DefaultLoadBalancer,
+		CircuitBreaker: circuitbreaker.DefaultCircuitBreaker,
+	}
+	for _, o := range opt {
+		o(opts)
+	}
+
+	var (
+		list []*registry.Node
+		err  error
+	)
+	if _, ok := opts.Discovery.(*discovery.IPDiscovery); ok {
+		// IPDiscovery echoes the name, split it like the ip:// targets.
+		list = addressNodes(serviceName)
+	} else if list, err = opts.Discovery.List(serviceName, opts.DiscoveryOptions...); err != nil {
+		return nil, err
+	}
+	if !opts.DisableServiceRouter {
+		if list, err = opts.ServiceRouter.Filter(serviceName, list, opts.ServiceRouterOptions...); err != nil {
+			return nil, err
+		}
+	}
+
+	// With a Peeker only the node picked by the load balancer is granted a call, a half open
+	// node would otherwise give its probes to the calls sent to the other nodes.
+	peeker, peek := opts.CircuitBreaker.(Peeker)
+	available := make([]*registry.Node, 0, len(list))
+	for _, n := range list {
+		if peek && peeker.Peek(n) || !peek && opts.CircuitBreaker.Available(n) {
+			available = append(available, n)
+		}
+	}
+
+	var node *registry.Node
+	for {
+		if len(available) == 0 {
+			return nil, fmt.Errorf("no available node of %s, %d open", serviceName, len(list))
+		}
+		if node, err = opts.LoadBalancer.Select(serviceName, available, opts.LoadBalanceOptions...); err != nil {
+			return nil, err
+		}
+		if !peek || opts.CircuitBreaker.Available(node) {
+			break
+		}
+		// Its last probe was granted to another call meanwhile.
+		if r, ok := opts.LoadBalancer.(Reporter); ok {
+			_ = r.Report(node, 0, errNotGranted)
+		}
+		available = withoutNode(available, node)
+	}
+	// The discovery may share its nodes between calls, Metadata is set on a copy.
+	n := *node
+	n.Metadata = make(map[string]interface{}, len(node.Metadata)+1)
+	for k, v := range node.Metadata {
+		n.Metadata[k] = v
+	}
+	n.Metadata["circuitbreaker"] = opts.CircuitBreaker
+	if r, ok := opts.LoadBalancer.(Reporter); ok {
+		n.Metadata["loadbalance"] = r
+	}
+	return &n, nil
+}
+
+// Peeker is implemented by the circuit breakers which can tell whether a node is available
+// without granting it a call, e.g. a half open probe, Available granting it.
+type Peeker interface {
+	Peek(node *registry.Node) bool
+}
+
+// errNotGranted is reported to the load balancers for a node they selected but the circuit
+// breaker refused, as no call is sent to it.
+var errNotGranted = errors.New("call not granted by the circuit breaker")
+
+// withoutNode returns list without node.
+func withoutNode(list []*registry.Node, node *registry.Node) []*registry.Node {
+	rest := make([]*registry.Node, 0, len(list))
+	for _, n := range list {
+		if n.Address != node.Address {
+			rest = append(rest, n)
+		}
+	}
+	return rest
+}
+
+// Reporter is implemented by the load balancers fed with the results of the calls, which
+// Selector reports to them as well as to the circuit breaker.
+type Reporter interface {
+	Report(node *registry.Node, cost time.Duration, err error) error
+}
+
+// Report reports the result of a call to the load balancer which selected node, if it is
+// a Reporter, and to the circuit breaker which allowed it.
+func (s *Selector) Report(node *registry.Node, cost time.Duration, err error) error {
+	if node == nil {
+		return selector.ErrReportNodeEmpty
+	}
+	if r, ok := node.Metadata["loadbalance"].(Reporter); ok {
+		if err := r.Report(node, cost, err); err != nil {
+			return err
+		}
+	}
+	b, ok := node.Metadata["circuitbreaker"].(circuitbreaker.CircuitBreaker)
+	if !ok {
+		return selector.ErrReportNoCircuitBreaker
+	}
+	return b.Report(node, cost, err)
+}
+
+// addressNodes returns the nodes of a comma separated list of addresses.
+func addressNodes(endpoint string) []*registry.Node {
+	addrs := strings.Split(endpoint, ",")
+	list := make([]*registry.Node, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, &registry.Node{ServiceName: endpoint, Address: addr})
+	}
+	return list
+}
+
+// SelectorPluginFactory makes Selector the default selector, used by the clients without target.
+type SelectorPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *SelectorPluginFactory) Type() string {
+	return "selector"
+}
+
+// Setup sets the default selector, the plugin has no config.
+func (f *SelectorPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	selector.DefaultSelector = &Selector{}
+	return nil
+}
+
+func init() {
+	selector.Register(SelectorName, &Selector{})
+	plugin.Register(SelectorName, &SelectorPluginFactory{})
+}
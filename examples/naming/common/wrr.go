@@ -0,0 +1,159 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/naming/loadbalance"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/plugin"
+)
+
+// WRRName is the name of WRRBalancer, selected by `loadbalance: smooth_wrr` in the client config.
+const WRRName = "smooth_wrr"
+
+// WRRConfig is the `plugins.loadbalance.smooth_wrr` section of trpc_go.yaml.
+//
+//	plugins:
+//	  loadbalance:
+//	    smooth_wrr:
+//	      slow_start: 30s
+type WRRConfig struct {
+	SlowStart time.Duration `yaml:"slow_start"` // ramp-up of the weight of new nodes, 0 disables it
+}
+
+// wrrNode is the round-robin state of one node.
+type wrrNode struct {
+	current int       // current weight of the smooth round-robin
+	since   time.Time // when the node appeared in the list
+}
+
+// wrrService is the round-robin state of the nodes of one service.
+type wrrService struct {
+	mu    sync.Mutex
+	nodes map[string]*wrrNode // address => state
+}
+
+// WRRBalancer is the smooth weighted round-robin of nginx: every node gains its weight at each
+// call, the node with the highest current weight is selected and loses the total. Over a round
+// nodes are selected in proportion to their weight, interleaved rather than in bursts.
+//
+// Weights come from registry.Node, so that a rollout drains a node by lowering its weight down
+// to 0: a node of weight 0 gets no call. A list without any weight, e.g. of IP addresses, is
+// round-robin with equal weights. With slow_start, the weight of a node appearing in the list
+// grows linearly from 1 over the duration, including the nodes back from the circuit breaker.
+type WRRBalancer struct {
+	cfg      WRRConfig
+	services sync.Map // service name => *wrrService
+}
+
+// NewWRRBalancer creates a balancer.
+func NewWRRBalancer(cfg WRRConfig) *WRRBalancer {
+	return &WRRBalancer{cfg: cfg}
+}
+
+// Select returns the next node of the round-robin of serviceName.
+func (b *WRRBalancer) Select(serviceName string, list []*registry.Node, opt ...loadbalance.Option) (*registry.Node, error) {
+	if len(list) == 0 {
+		return nil, loadbalance.ErrNoServerAvailable
+	}
+	v, ok := b.services.Load(serviceName)
+	if !ok {
+		v, _ = b.services.LoadOrStore(serviceName, &wrrService{nodes: make(map[string]*wrrNode)})
+	}
+	s := v.(*wrrService)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unweighted := true
+	for _, n := range list {
+		if n.Weight > 0 {
+			unweighted = false
+			break
+		}
+	}
+	var (
+		picked      *registry.Node
+		pickedState *wrrNode
+		total       int
+	)
+	for _, n := range list {
+		st, ok := s.nodes[n.Address]
+		if !ok {
+			st = &wrrNode{since: now}
+			s.nodes[n.Address] = st
+		}
+		w := b.weight(n, unweighted, st, now)
+		if w == 0 {
+			// Drained, it ramps up again when its weight comes back.
+			st.current, st.since = 0, now
+			continue
+		}
+		st.current += w
+		total += w
+		if pickedState == nil || st.current > pickedState.current {
+			picked, pickedState = n, st
+		}
+	}
+	pickedState.current -= total
+
+	if len(s.nodes) > len(list) {
+		// Forget the nodes gone from the list, they ramp up again if they come back.
+		listed := make(map[string]bool, len(list))
+		for _, n := range list {
+			listed[n.Address] = true
+		}
+		for addr := range s.nodes {
+			if !listed[addr] {
+				delete(s.nodes, addr)
+			}
+		}
+	}
+	return picked, nil
+}
+
+// weight returns the effective weight of n, reduced during its slow start, 0 if it is drained.
+func (b *WRRBalancer) weight(n *registry.Node, unweighted bool, st *wrrNode, now time.Time) int {
+	w := n.Weight
+	if unweighted {
+		w = 100
+	}
+	if w <= 0 {
+		return 0
+	}
+	if elapsed := now.Sub(st.since); elapsed < b.cfg.SlowStart {
+		w = int(int64(w) * int64(elapsed) / int64(b.cfg.SlowStart))
+		if w < 1 {
+			w = 1
+		}
+	}
+	return w
+}
+
+func init() {
+	loadbalance.Register(WRRName, NewWRRBalancer(WRRConfig{}))
+}
+
+// WRRPluginFactory configures the balancer registered as smooth_wrr.
+type WRRPluginFactory struct{}
+
+// Type returns the plugin type.
+func (f *WRRPluginFactory) Type() string {
+	return "loadbalance"
+}
+
+// Setup decodes the config and registers a new balancer.
+func (f *WRRPluginFactory) Setup(name string, dec plugin.Decoder) error {
+	var cfg WRRConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	loadbalance.Register(WRRName, NewWRRBalancer(cfg))
+	return nil
+}
+
+func init() {
+	plugin.Register(WRRName, &WRRPluginFactory{})
+}
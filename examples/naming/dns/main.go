@@ -0,0 +1,69 @@
+// Command dns calls the nodes of examples/naming/server found through DNS SRV records, served
+// by an in-process stand-in DNS server whose records change while it runs.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	pb "trpc-go-note/examples/helloworld/pb"
+	"trpc-go-note/examples/naming/common"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/naming/selector"
+)
+
+const srvName = "_greeter._tcp.example.local."
+
+func main() {
+	dns, err := common.NewDNSStandIn()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dns.Close()
+	// Both nodes of examples/naming/server, the addresses of the targets are not in the
+	// additional section, so they are resolved too.
+	dns.Set(srvName, dnsmessage.TypeSRV, 1,
+		&dnsmessage.SRVResource{Priority: 10, Weight: 100, Port: 8000, Target: dnsmessage.MustNewName("a.example.local.")},
+		&dnsmessage.SRVResource{Priority: 10, Weight: 100, Port: 8001, Target: dnsmessage.MustNewName("b.example.local.")},
+	)
+	dns.Set("a.example.local.", dnsmessage.TypeA, 1, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+	dns.Set("b.example.local.", dnsmessage.TypeA, 1, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+
+	// What the dns plugin does with `plugins.discovery.dns.server` set to the stand-in.
+	d := common.NewDNSDiscovery(common.DNSDiscoveryConfig{Server: dns.Addr(), MinTTL: time.Second})
+	defer d.Close()
+	selector.Register(common.DNSDiscoveryName, &common.DNSSelector{Discovery: d})
+
+	proxy := pb.NewGreeterClientProxy(client.WithTarget("dns://" + srvName))
+	for sec := 1; sec <= 9; sec++ {
+		switch sec {
+		case 4:
+			fmt.Println("--- b removed from the SRV records, gone once the TTL expires ---")
+			dns.Set(srvName, dnsmessage.TypeSRV, 1,
+				&dnsmessage.SRVResource{Priority: 10, Weight: 100, Port: 8000, Target: dnsmessage.MustNewName("a.example.local.")},
+			)
+		case 7:
+			fmt.Println("--- DNS failing, the last good nodes are kept ---")
+			dns.SetFail(true)
+		}
+
+		served := map[string]int{}
+		var failed int
+		for end := time.Now().Add(time.Second); time.Now().Before(end); {
+			var node registry.Node
+			_, err := proxy.Hello(context.Background(), &pb.HelloRequest{Msg: "World"}, client.WithSelectorNode(&node))
+			if err != nil {
+				failed++
+			} else {
+				served[node.Address]++
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		fmt.Printf("%ds served: %v, failed: %d\n", sec, served, failed)
+	}
+}
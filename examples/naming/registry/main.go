@@ -0,0 +1,30 @@
+// Command registry runs the embedded registry standalone, for the servers which do not host it:
+//
+//	plugins:
+//	  registry:
+//	    embedded:
+//	      address: http://127.0.0.1:9100/cmds/registry
+package main
+
+import (
+	"flag"
+	"log"
+
+	"trpc-go-note/examples/naming/common"
+)
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:9100", "address to listen to")
+	ttl     = flag.Duration("ttl", 0, "ttl of the nodes registered without ttl, default 10s")
+	maxWait = flag.Duration("max_wait", 0, "longest long poll, default 50s")
+)
+
+func main() {
+	flag.Parse()
+
+	s := common.NewRegistryServer(common.RegistryServerConfig{TTL: *ttl, MaxWait: *maxWait})
+	log.Printf("registry serving at http://%s%s", *addr, common.RegistryPath)
+	if err := s.ListenAndServe(*addr); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	pb "trpc-go-note/examples/helloworld/pb"
+	_ "trpc-go-note/examples/naming/common" // the embedded registry
+
+	trpc "trpc.group/trpc-go/trpc-go"
+)
+
+var (
+	degraded = flag.Duration("degraded", 5*time.Second, "how long node b is slow after starting")
+	delay    = flag.Duration("delay", 300*time.Millisecond, "latency of node b while degraded")
+)
+
+type greeterImpl struct {
+	node     string
+	degraded time.Time // slow until then
+}
+
+func (s *greeterImpl) Hello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	if time.Now().Before(s.degraded) {
+		time.Sleep(*delay)
+	}
+	return &pb.HelloReply{Msg: "Hello " + req.Msg + " from " + s.node}, nil
+}
+
+func main() {
+	s := trpc.NewServer()
+
+	pb.RegisterGreeterService(s.Service("trpc.helloworld.Greeter.a"), &greeterImpl{node: "a"})
+	pb.RegisterGreeterService(s.Service("trpc.helloworld.Greeter.b"), &greeterImpl{
+		node:     "b",
+		degraded: time.Now().Add(*degraded),
+	})
+	if err := s.Serve(); err != nil {
+		fmt.Println(err)
+	}
+}